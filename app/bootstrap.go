@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+)
+
+// createAdmin implements the create-admin command, which creates the first
+// administrator or promotes an existing user:
+//
+//	app create-admin -email admin@example.com -name Admin
+//
+// The password is read from -password or, preferably, the ADMIN_PASSWORD
+// environment variable so it does not end up in shell history.
+func createAdmin(args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	email := fs.String("email", "", "administrator email (required)")
+	name := fs.String("name", "Administrator", "administrator display name")
+	password := fs.String("password", os.Getenv("ADMIN_PASSWORD"), "password for a new account (defaults to $ADMIN_PASSWORD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	var user models.User
+	err := database.DB.Where("email = ?", *email).First(&user).Error
+	if err == nil {
+		roles, _, err := auth.LoadRoles(user.ID)
+		if err != nil {
+			return err
+		}
+		err = database.DB.Transaction(func(tx *gorm.DB) error {
+			return auth.AssignRoles(tx, &user, append([]string{"admin"}, roles...)...)
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Promoted existing user %d (%s) to admin", user.ID, user.Email)
+		return nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if *password == "" {
+		return errors.New("a password is required for a new account (-password or ADMIN_PASSWORD)")
+	}
+	if err := auth.ValidatePassword(*password); err != nil {
+		return err
+	}
+
+	hashedPassword, err := auth.HashPassword(*password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	user = models.User{
+		Email:           *email,
+		Name:            *name,
+		Password:        hashedPassword,
+		EmailVerifiedAt: &now,
+	}
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return auth.AssignRoles(tx, &user, "admin")
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Created admin user %d (%s)", user.ID, user.Email)
+	return nil
+}
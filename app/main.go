@@ -4,10 +4,19 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
 	"github.com/MdHisham-04/E-Commerce/internal/database"
 	"github.com/MdHisham-04/E-Commerce/internal/handlers"
+	"github.com/MdHisham-04/E-Commerce/internal/jobs"
+	"github.com/MdHisham-04/E-Commerce/internal/lockout"
+	"github.com/MdHisham-04/E-Commerce/internal/mailer"
 	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc"
+	"github.com/MdHisham-04/E-Commerce/internal/storage"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
@@ -38,7 +47,123 @@ func main() {
 		log.Fatal("Failed to migrate database:", err)
 	}
 
+	if err := auth.SeedRoles(); err != nil {
+		log.Fatal("Failed to seed roles:", err)
+	}
+
+	err := auth.ConfigurePasswordHashing(auth.HashConfig{
+		Algorithm:     getEnv("PASSWORD_HASH", auth.DefaultHashConfig.Algorithm),
+		BcryptCost:    getEnvInt("BCRYPT_COST", auth.DefaultHashConfig.BcryptCost),
+		Argon2Time:    uint32(getEnvInt("ARGON2_TIME", int(auth.DefaultHashConfig.Argon2Time))),
+		Argon2Memory:  uint32(getEnvInt("ARGON2_MEMORY_KIB", int(auth.DefaultHashConfig.Argon2Memory))),
+		Argon2Threads: uint8(getEnvInt("ARGON2_THREADS", int(auth.DefaultHashConfig.Argon2Threads))),
+	})
+	if err != nil {
+		log.Fatal("Invalid password hashing settings: ", err)
+	}
+	err = auth.ConfigurePasswordPolicy(
+		getEnvInt("PASSWORD_MIN_LENGTH", 8),
+		getEnvInt("PASSWORD_MAX_LENGTH", 128),
+		getEnv("BREACHED_PASSWORDS_FILE", ""),
+	)
+	if err != nil {
+		log.Fatal("Invalid password policy: ", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "create-admin" {
+		if err := createAdmin(os.Args[2:]); err != nil {
+			log.Fatal("create-admin: ", err)
+		}
+		return
+	}
+
+	rotation, err := time.ParseDuration(getEnv("JWT_KEY_ROTATION", "720h"))
+	if err != nil {
+		log.Fatal("Invalid JWT_KEY_ROTATION:", err)
+	}
+
+	if err := auth.InitKeys(auth.KeyConfig{
+		Algorithm:        getEnv("JWT_ALG", "RS256"),
+		Secret:           os.Getenv("JWT_SECRET"),
+		Environment:      getEnv("APP_ENV", "production"),
+		Issuer:           getEnv("JWT_ISSUER", "ecommerce-api"),
+		Audience:         getEnv("JWT_AUDIENCE", "ecommerce-api"),
+		RotationInterval: rotation,
+	}); err != nil {
+		log.Fatal("Failed to initialize signing keys:", err)
+	}
+	go auth.StartKeyRotation()
+
+	mail, err := mailer.New(mailer.Config{
+		Driver:   getEnv("MAIL_DRIVER", "file"),
+		From:     getEnv("MAIL_FROM", "no-reply@localhost"),
+		Dir:      getEnv("MAIL_DIR", "./mail"),
+		Host:     getEnv("SMTP_HOST", ""),
+		Port:     getEnv("SMTP_PORT", "587"),
+		Username: getEnv("SMTP_USERNAME", ""),
+		Password: getEnv("SMTP_PASSWORD", ""),
+	})
+	if err != nil {
+		log.Fatal("Failed to configure mailer:", err)
+	}
+	handlers.Mailer = mail
+	handlers.AppURL = getEnv("APP_URL", "http://localhost:"+getEnv("PORT", "8080"))
+	handlers.RequireEmailVerification = getEnv("REQUIRE_EMAIL_VERIFICATION", "false") == "true"
+	handlers.MFAIssuer = getEnv("MFA_ISSUER", handlers.MFAIssuer)
+	middleware.TrustedProxies = getEnvInt("TRUSTED_PROXIES", 0)
+	if middleware.TrustedProxies == 0 && getEnv("TRUST_PROXY", "false") == "true" {
+		// TRUST_PROXY predates TRUSTED_PROXIES and means a single proxy
+		middleware.TrustedProxies = 1
+	}
+	handlers.OIDCProviders = loadOIDCProviders(handlers.AppURL)
+	handlers.ExportDir = getEnv("EXPORT_DIR", handlers.ExportDir)
+	handlers.MaxImageBytes = int64(getEnvInt("MAX_IMAGE_BYTES", int(handlers.MaxImageBytes)))
+	handlers.MaxImportBytes = int64(getEnvInt("MAX_IMPORT_BYTES", int(handlers.MaxImportBytes)))
+	handlers.ReviewReportThreshold = getEnvInt("REVIEW_REPORT_THRESHOLD", handlers.ReviewReportThreshold)
+	handlers.DeletedRetention = time.Duration(getEnvInt("DELETED_RETENTION_DAYS", 30)) * 24 * time.Hour
+
+	files, err := storage.New(storage.Config{
+		Driver:          getEnv("STORAGE_DRIVER", "local"),
+		Dir:             getEnv("UPLOAD_DIR", "./uploads"),
+		BaseURL:         getEnv("UPLOAD_URL", handlers.AppURL+"/uploads"),
+		Endpoint:        getEnv("S3_ENDPOINT", ""),
+		Region:          getEnv("S3_REGION", "us-east-1"),
+		Bucket:          getEnv("S3_BUCKET", ""),
+		AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+		SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		PublicURL:       getEnv("S3_PUBLIC_URL", ""),
+		PathStyle:       getEnv("S3_PATH_STYLE", "false") == "true",
+	})
+	if err != nil {
+		log.Fatal("Failed to configure file storage:", err)
+	}
+	handlers.Storage = files
+
+	var attempts lockout.Store
+	switch getEnv("LOCKOUT_STORE", "postgres") {
+	case "memory":
+		attempts = lockout.NewMemoryStore()
+	case "postgres":
+		attempts = lockout.NewPostgresStore(database.DB)
+	default:
+		log.Fatal("Invalid LOCKOUT_STORE, expected memory or postgres")
+	}
+	handlers.AccountLimiter = lockout.New(attempts, handlers.AccountPolicy)
+	handlers.IPLimiter = lockout.New(attempts, handlers.IPPolicy)
+
+	jobs.Register(handlers.JobExportUserData, handlers.ExportUserDataJob)
+	jobs.Register(handlers.JobEraseUserData, handlers.EraseUserDataJob)
+	jobs.Register(handlers.JobImportProducts, handlers.ImportProductsJob)
+	go jobs.Start(5 * time.Second)
+
+	go purgeExpiredRecords()
+
 	router := mux.NewRouter()
+	router.HandleFunc("/.well-known/jwks.json", handlers.JWKS).Methods("GET")
+	if local, ok := files.(*storage.LocalStorage); ok {
+		router.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads", local.Handler()))
+	}
+
 	api := router.PathPrefix("/api").Subrouter()
 
 	api.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -48,39 +173,161 @@ func main() {
 
 	api.HandleFunc("/auth/register", handlers.Register).Methods("POST")
 	api.HandleFunc("/auth/login", handlers.Login).Methods("POST")
+	api.HandleFunc("/auth/mfa/verify", handlers.VerifyMFA).Methods("POST")
+	api.HandleFunc("/auth/refresh", handlers.Refresh).Methods("POST")
+	api.HandleFunc("/auth/forgot-password", handlers.ForgotPassword).Methods("POST")
+	api.HandleFunc("/auth/reset-password", handlers.ResetPassword).Methods("POST")
+	api.HandleFunc("/auth/verify-email", handlers.VerifyEmail).Methods("GET", "POST")
+	api.HandleFunc("/auth/verify-email/resend", handlers.ResendVerification).Methods("POST")
+	api.HandleFunc("/auth/unlock", handlers.UnlockAccount).Methods("POST")
+	api.HandleFunc("/auth/confirm-email-change", handlers.ConfirmEmailChange).Methods("GET", "POST")
+	api.HandleFunc("/auth/oidc/{provider}/start", handlers.OIDCStart).Methods("GET")
+	api.HandleFunc("/auth/oidc/{provider}/callback", handlers.OIDCCallback).Methods("GET")
 
 	api.HandleFunc("/products", handlers.GetProducts).Methods("GET")
+	api.HandleFunc("/products/search", handlers.SearchProducts).Methods("GET")
+	api.HandleFunc("/products/suggest", handlers.SuggestProducts).Methods("GET")
 	api.HandleFunc("/products/{id}", handlers.GetProduct).Methods("GET")
+	api.HandleFunc("/products/{id}/reviews", handlers.GetProductReviews).Methods("GET")
+	api.HandleFunc("/products/{id}/reviews/summary", handlers.GetReviewSummary).Methods("GET")
+	api.HandleFunc("/categories", handlers.GetCategories).Methods("GET")
+
+	requireSellerMFA := getEnv("REQUIRE_SELLER_MFA", "false") == "true"
 
 	protected := api.PathPrefix("").Subrouter()
 	protected.Use(middleware.AuthMiddleware)
 
-	protected.HandleFunc("/users/{user_id}/cart", handlers.GetCart).Methods("GET")
-	protected.HandleFunc("/users/{user_id}/cart", handlers.AddToCart).Methods("POST")
-	protected.HandleFunc("/users/{user_id}/cart/{item_id}", handlers.RemoveFromCart).Methods("DELETE")
-	protected.HandleFunc("/users/{user_id}/orders", handlers.GetOrders).Methods("GET")
-	protected.HandleFunc("/users/{user_id}/orders", handlers.CreateOrder).Methods("POST")
+	protected.Handle("/auth/logout", guard(middleware.DenyAPIKeys, handlers.Logout)).Methods("POST")
+
+	me := protected.PathPrefix("/me").Subrouter()
+	me.Use(middleware.DenyAPIKeys, middleware.DenyImpersonation)
+
+	me.HandleFunc("", handlers.GetMe).Methods("GET")
+	me.HandleFunc("", handlers.UpdateMe).Methods("PATCH")
+	me.HandleFunc("", handlers.DeleteAccount).Methods("DELETE")
+	me.HandleFunc("/password", handlers.ChangePassword).Methods("PUT")
+	me.HandleFunc("/email", handlers.ChangeEmail).Methods("PUT")
+
+	me.HandleFunc("/mfa/totp/setup", handlers.SetupTOTP).Methods("POST")
+	me.HandleFunc("/mfa/totp/confirm", handlers.ConfirmTOTP).Methods("POST")
+	me.HandleFunc("/mfa/totp/disable", handlers.DisableTOTP).Methods("POST")
+	me.HandleFunc("/mfa/recovery-codes", handlers.RegenerateRecoveryCodes).Methods("POST")
+	me.HandleFunc("/sessions", handlers.GetSessions).Methods("GET")
+	me.HandleFunc("/sessions", handlers.RevokeOtherSessions).Methods("DELETE")
+	me.HandleFunc("/sessions/{id}", handlers.RevokeSession).Methods("DELETE")
+	me.HandleFunc("/data-exports", handlers.GetDataExports).Methods("GET")
+	me.HandleFunc("/data-exports", handlers.RequestDataExport).Methods("POST")
+	me.HandleFunc("/data-exports/{id}", handlers.GetDataExport).Methods("GET")
+	me.HandleFunc("/data-exports/{id}/download", handlers.DownloadDataExport).Methods("GET")
+
+	apiKeys := me.PathPrefix("/api-keys").Subrouter()
+	if requireSellerMFA {
+		apiKeys.Use(middleware.RequireMFA)
+	}
+
+	apiKeys.HandleFunc("", handlers.GetAPIKeys).Methods("GET")
+	apiKeys.HandleFunc("", handlers.CreateAPIKey).Methods("POST")
+	apiKeys.HandleFunc("/{id}", handlers.RevokeAPIKey).Methods("DELETE")
+
+	reviews := protected.PathPrefix("/products/{id}/reviews").Subrouter()
+	reviews.Use(middleware.DenyAPIKeys, middleware.DenyImpersonation)
+
+	reviews.HandleFunc("", handlers.CreateReview).Methods("POST")
+	reviews.HandleFunc("/{review_id}", handlers.UpdateReview).Methods("PUT")
+	reviews.HandleFunc("/{review_id}", handlers.DeleteReview).Methods("DELETE")
+	reviews.HandleFunc("/{review_id}/report", handlers.ReportReview).Methods("POST")
+
+	users := protected.PathPrefix("/users/{user_id}").Subrouter()
+
+	users.Handle("/cart", guard(middleware.RequireSelfOrPermission("user_id", auth.PermCartReadAny), handlers.GetCart)).Methods("GET")
+	users.Handle("/cart", guard(middleware.RequireSelfWithPermission("user_id", auth.PermCartWrite), handlers.AddToCart)).Methods("POST")
+	users.Handle("/cart/{item_id}", guard(middleware.RequireSelfWithPermission("user_id", auth.PermCartWrite), handlers.RemoveFromCart)).Methods("DELETE")
+	users.Handle("/orders", guard(middleware.RequireSelfOrPermission("user_id", auth.PermOrderReadAny), handlers.GetOrders)).Methods("GET")
+	users.Handle("/orders", guard(middleware.RequireSelfWithPermission("user_id", auth.PermOrderCreate), handlers.CreateOrder)).Methods("POST")
 
 	seller := protected.PathPrefix("/seller").Subrouter()
-	seller.Use(middleware.RequireRole("seller"))
+	if requireSellerMFA {
+		seller.Use(middleware.RequireMFA)
+	}
+
+	seller.Handle("/products", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.GetSellerProducts)).Methods("GET")
+	seller.Handle("/products", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.CreateProduct)).Methods("POST")
+	seller.Handle("/products/import", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.ImportProducts)).Methods("POST")
+	seller.Handle("/products/import", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.GetProductImports)).Methods("GET")
+	seller.Handle("/products/import/{job_id}", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.GetProductImport)).Methods("GET")
+	seller.Handle("/products/export", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.ExportProducts)).Methods("GET")
+	seller.Handle("/products/{id}", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.GetSellerProduct)).Methods("GET")
+	seller.Handle("/products/{id}", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.UpdateProduct)).Methods("PUT")
+	seller.Handle("/products/{id}/status", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.UpdateProductStatus)).Methods("PATCH")
+	seller.Handle("/products/{id}/stock", guard(middleware.RequirePermission(auth.PermProductStock), handlers.UpdateProductStock)).Methods("PATCH")
+	seller.Handle("/products/{id}", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.DeleteProduct)).Methods("DELETE")
+	seller.Handle("/products/{id}/categories", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.SetProductCategories)).Methods("PUT")
+	seller.Handle("/products/{id}/images", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.UploadProductImage)).Methods("POST")
+	seller.Handle("/products/{id}/images/order", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.ReorderProductImages)).Methods("PUT")
+	seller.Handle("/products/{id}/images/{image_id}/primary", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.SetPrimaryImage)).Methods("PUT")
+	seller.Handle("/products/{id}/images/{image_id}", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.DeleteProductImage)).Methods("DELETE")
+	seller.Handle("/products/{id}/options", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.SetProductOptions)).Methods("PUT")
+	seller.Handle("/products/{id}/variants", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.CreateVariant)).Methods("POST")
+	seller.Handle("/products/{id}/variants/{variant_id}", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.UpdateVariant)).Methods("PUT")
+	seller.Handle("/products/{id}/variants/{variant_id}/stock", guard(middleware.RequirePermission(auth.PermProductStock), handlers.UpdateVariantStock)).Methods("PATCH")
+	seller.Handle("/products/{id}/variants/{variant_id}", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.DeleteVariant)).Methods("DELETE")
+
+	seller.Handle("/reviews", guard(middleware.RequirePermission(auth.PermProductReadOwn), handlers.GetSellerReviews)).Methods("GET")
+	seller.Handle("/reviews/{id}/reply", guard(middleware.RequirePermission(auth.PermProductWrite), handlers.ReplyToReview)).Methods("PUT")
+
+	seller.Handle("/orders", guard(middleware.RequirePermission(auth.PermOrderReadSeller), handlers.GetAllOrders)).Methods("GET")
+	seller.Handle("/orders/pending", guard(middleware.RequirePermission(auth.PermOrderReadSeller), handlers.GetPendingOrders)).Methods("GET")
+	seller.Handle("/order-items/{item_id}/status", guard(middleware.RequirePermission(auth.PermOrderFulfill), handlers.UpdateOrderItemStatus)).Methods("PATCH")
+
+	seller.Handle("/dashboard/stats", guard(middleware.RequirePermission(auth.PermStatsReadSeller), handlers.GetDashboardStats)).Methods("GET")
+
+	admin := protected.PathPrefix("/admin").Subrouter()
+
+	admin.Handle("/users", guard(middleware.RequirePermission(auth.PermUserRead), handlers.GetUsers)).Methods("GET")
+	admin.Handle("/users", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.CreateUser)).Methods("POST")
+	admin.Handle("/users/deleted", guard(middleware.RequirePermission(auth.PermUserRead), handlers.AdminGetDeletedUsers)).Methods("GET")
+	admin.Handle("/users/{id}", guard(middleware.RequirePermission(auth.PermUserRead), handlers.GetUser)).Methods("GET")
+	admin.Handle("/users/{id}", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminDeleteUser)).Methods("DELETE")
+	admin.Handle("/users/{id}/restore", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminRestoreUser)).Methods("POST")
+	admin.Handle("/users/{id}/roles", guard(middleware.RequirePermission(auth.PermRoleWrite), handlers.UpdateUserRoles)).Methods("PUT")
+	admin.Handle("/users/{id}/suspend", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.SuspendUser)).Methods("POST")
+	admin.Handle("/users/{id}/unsuspend", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.UnsuspendUser)).Methods("POST")
+	admin.Handle("/users/{id}/data-export", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminExportUserData)).Methods("POST")
+	admin.Handle("/users/{id}/erase", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminEraseUserData)).Methods("POST")
+	admin.Handle("/users/{id}/impersonate", guard(middleware.RequirePermission(auth.PermUserImpersonate), handlers.Impersonate)).Methods("POST")
+	admin.Handle("/impersonations/{token_id}", guard(middleware.RequirePermission(auth.PermUserImpersonate), handlers.EndImpersonation)).Methods("DELETE")
+	admin.Handle("/audit-logs", guard(middleware.RequirePermission(auth.PermAuditRead), handlers.GetAuditLogs)).Methods("GET")
+	admin.Handle("/jobs/{id}", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminGetJob)).Methods("GET")
+	admin.Handle("/jobs/{id}/download", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminDownloadJob)).Methods("GET")
+
+	admin.Handle("/roles", guard(middleware.RequirePermission(auth.PermUserRead), handlers.GetRoles)).Methods("GET")
+	admin.Handle("/roles", guard(middleware.RequirePermission(auth.PermRoleWrite), handlers.CreateRole)).Methods("POST")
+	admin.Handle("/roles/{name}/permissions", guard(middleware.RequirePermission(auth.PermRoleWrite), handlers.UpdateRolePermissions)).Methods("PUT")
+
+	admin.Handle("/products/deleted", guard(middleware.RequirePermission(auth.PermProductDeleteAny), handlers.AdminGetDeletedProducts)).Methods("GET")
+	admin.Handle("/products/{id}", guard(middleware.RequirePermission(auth.PermProductDeleteAny), handlers.AdminDeleteProduct)).Methods("DELETE")
+	admin.Handle("/products/{id}/restore", guard(middleware.RequirePermission(auth.PermProductDeleteAny), handlers.AdminRestoreProduct)).Methods("POST")
 
-	seller.HandleFunc("/products", handlers.GetSellerProducts).Methods("GET")
-	seller.HandleFunc("/products", handlers.CreateProduct).Methods("POST")
-	seller.HandleFunc("/products/{id}", handlers.UpdateProduct).Methods("PUT")
-	seller.HandleFunc("/products/{id}/stock", handlers.UpdateProductStock).Methods("PATCH")
-	seller.HandleFunc("/products/{id}", handlers.DeleteProduct).Methods("DELETE")
+	admin.Handle("/cart-items/deleted", guard(middleware.RequirePermission(auth.PermUserRead), handlers.AdminGetDeletedCartItems)).Methods("GET")
+	admin.Handle("/cart-items/{id}/restore", guard(middleware.RequirePermission(auth.PermUserWrite), handlers.AdminRestoreCartItem)).Methods("POST")
 
-	seller.HandleFunc("/orders", handlers.GetAllOrders).Methods("GET")
-	seller.HandleFunc("/orders/pending", handlers.GetPendingOrders).Methods("GET")
-	seller.HandleFunc("/order-items/{item_id}/status", handlers.UpdateOrderItemStatus).Methods("PATCH")
+	admin.Handle("/categories", guard(middleware.RequirePermission(auth.PermCategoryWrite), handlers.CreateCategory)).Methods("POST")
+	admin.Handle("/categories/{id}", guard(middleware.RequirePermission(auth.PermCategoryWrite), handlers.UpdateCategory)).Methods("PUT")
+	admin.Handle("/categories/{id}", guard(middleware.RequirePermission(auth.PermCategoryWrite), handlers.DeleteCategory)).Methods("DELETE")
 
-	seller.HandleFunc("/dashboard/stats", handlers.GetDashboardStats).Methods("GET")
+	admin.Handle("/reviews", guard(middleware.RequirePermission(auth.PermReviewModerate), handlers.AdminGetReviews)).Methods("GET")
+	admin.Handle("/reviews/{id}/status", guard(middleware.RequirePermission(auth.PermReviewModerate), handlers.ModerateReview)).Methods("PUT")
+
+	admin.Handle("/orders", guard(middleware.RequirePermission(auth.PermOrderReadAny), handlers.AdminGetOrders)).Methods("GET")
+	admin.Handle("/orders/{order_id}", guard(middleware.RequirePermission(auth.PermOrderReadAny), handlers.GetOrder)).Methods("GET")
+
+	admin.Handle("/stats", guard(middleware.RequirePermission(auth.PermStatsReadPlatform), handlers.GetPlatformStats)).Methods("GET")
 
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./assets")))
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"*"},
 		AllowCredentials: true,
 	})
@@ -92,6 +339,11 @@ func main() {
 	log.Fatal(http.ListenAndServe(":"+port, handler))
 }
 
+// guard wraps a single route's handler in middleware, for checks that differ per route
+func guard(mw func(http.Handler) http.Handler, h http.HandlerFunc) http.Handler {
+	return mw(h)
+}
+
 // getEnv retrieves environment variables with a fallback default value
 func getEnv(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -100,3 +352,66 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvInt reads an integer from the environment with a fallback
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, strconv.Itoa(defaultValue)))
+	if err != nil {
+		log.Fatalf("Invalid %s: expected an integer", key)
+	}
+	return value
+}
+
+// loadOIDCProviders reads the providers named in OIDC_PROVIDERS (for example
+// "google,okta"), each configured by OIDC_<NAME>_ISSUER, _CLIENT_ID,
+// _CLIENT_SECRET, _REDIRECT_URL and _SCOPES
+func loadOIDCProviders(appURL string) map[string]*oidc.Provider {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(getEnv("OIDC_PROVIDERS", ""), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := oidc.Config{
+			Name:         name,
+			Issuer:       getEnv(prefix+"ISSUER", ""),
+			ClientID:     getEnv(prefix+"CLIENT_ID", ""),
+			ClientSecret: getEnv(prefix+"CLIENT_SECRET", ""),
+			RedirectURL:  getEnv(prefix+"REDIRECT_URL", appURL+"/api/auth/oidc/"+name+"/callback"),
+			Scopes:       strings.Fields(getEnv(prefix+"SCOPES", "")),
+		}
+		if config.Issuer == "" || config.ClientID == "" {
+			log.Fatalf("OIDC provider %s requires %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		providers[name] = oidc.NewProvider(config)
+		log.Printf("OIDC login enabled for %s", name)
+	}
+	return providers
+}
+
+// purgeExpiredRecords periodically removes expired tokens, stale login attempts,
+// old data exports, finished jobs and records deleted past their retention
+func purgeExpiredRecords() {
+	for range time.Tick(time.Hour) {
+		if err := auth.PurgeExpiredTokens(); err != nil {
+			log.Println("Failed to purge expired tokens:", err)
+		}
+		if err := handlers.AccountLimiter.Purge(); err != nil {
+			log.Println("Failed to purge login attempts:", err)
+		}
+		if err := handlers.IPLimiter.Purge(); err != nil {
+			log.Println("Failed to purge login attempts:", err)
+		}
+		if err := handlers.PurgeExpiredExports(); err != nil {
+			log.Println("Failed to purge data exports:", err)
+		}
+		if err := jobs.Purge(30 * 24 * time.Hour); err != nil {
+			log.Println("Failed to purge finished jobs:", err)
+		}
+		if err := handlers.PurgeDeletedRecords(); err != nil {
+			log.Println("Failed to purge deleted records:", err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+// Audited actions
+const (
+	ActionImpersonationStart   = "impersonation.start"
+	ActionImpersonationRequest = "impersonation.request"
+	ActionImpersonationEnd     = "impersonation.end"
+	ActionReviewModerate       = "review.moderate"
+)
+
+// Record appends an entry to the audit log. Callers should refuse the audited
+// action when it fails.
+func Record(actorID, subjectID int, action, ipAddress string, details map[string]interface{}) error {
+	entry := models.AuditLog{
+		ActorID:   actorID,
+		SubjectID: subjectID,
+		Action:    action,
+		IPAddress: ipAddress,
+		Details:   details,
+	}
+	return database.DB.Create(&entry).Error
+}
@@ -2,61 +2,192 @@ package auth
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-// jwtSecret is loaded from environment variable JWT_SECRET
-var jwtSecret = getJWTSecret()
+// AccessTokenTTL is how long an access token stays valid, loaded from ACCESS_TOKEN_TTL
+var AccessTokenTTL = getDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
 
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		log.Println("WARNING: JWT_SECRET not set, using default (insecure for production)")
-		secret = "your-secret-key-change-this-in-production"
+// getDuration reads a duration such as "15m" from the environment with a fallback
+func getDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
 	}
-	return []byte(secret)
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("WARNING: invalid %s %q, using %s", key, value, defaultValue)
+		return defaultValue
+	}
+	return d
 }
 
+// MaxImpersonationTTL caps how long an impersonation token stays valid
+const MaxImpersonationTTL = time.Hour
+
+// Token purposes for JWTs that must not be accepted as access tokens
+const PurposeMFAChallenge = "mfa"
+
 type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
-	Role   string `json:"role"`
+	UserID      int      `json:"user_id"`
+	Email       string   `json:"email"`
+	Role        string   `json:"role"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	MFA         bool     `json:"mfa,omitempty"`
+	Purpose     string   `json:"purpose,omitempty"`
+	SessionID   string   `json:"sid,omitempty"`
+	// ImpersonatorID is the staff member acting as UserID in an impersonation token
+	ImpersonatorID int  `json:"impersonator_id,omitempty"`
+	ReadOnly       bool `json:"read_only,omitempty"`
+	APIKeyID       int  `json:"-"` // set when the request authenticated with an API key
 	jwt.RegisteredClaims
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID int, email string, role string) (string, error) {
-	claims := Claims{
-		UserID: userID,
-		Email:  email,
-		Role:   role,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24 hours
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// GenerateToken signs a new short-lived JWT access token carrying the given claims
+func GenerateToken(claims Claims) (string, error) {
+	return signToken(claims, AccessTokenTTL)
+}
+
+// GenerateMFAChallenge issues a token proving the user passed the password step,
+// to be exchanged for an access token once the second factor is verified
+func GenerateMFAChallenge(userID int) (string, error) {
+	return signToken(Claims{UserID: userID, Purpose: PurposeMFAChallenge}, MFAChallengeTTL)
+}
+
+// GenerateImpersonationToken signs a token letting impersonatorID act as the
+// user described by claims. It cannot be refreshed and expires after ttl; its
+// ID can be passed to RevokeAccessToken to end the impersonation early.
+func GenerateImpersonationToken(claims Claims, impersonatorID int, readOnly bool, ttl time.Duration) (token, tokenID string, err error) {
+	if ttl > MaxImpersonationTTL {
+		ttl = MaxImpersonationTTL
 	}
+	claims.ImpersonatorID = impersonatorID
+	claims.ReadOnly = readOnly
+	claims.SessionID = ""
+	claims.MFA = false
+	claims.ID = newRandomToken(16)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token, err = signToken(claims, ttl)
+	return token, claims.ID, err
 }
 
-// ValidateToken validates a JWT token and returns the claims
+func signToken(claims Claims, ttl time.Duration) (string, error) {
+	if keys == nil {
+		return "", ErrKeysNotInitialized
+	}
+	key, err := keys.signer()
+	if err != nil {
+		return "", err
+	}
+
+	id := claims.ID
+	if id == "" {
+		id = newRandomToken(16)
+	}
+
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ID:        id,
+		Subject:   strconv.Itoa(claims.UserID),
+		Issuer:    keys.config.Issuer,
+		Audience:  jwt.ClaimStrings{keys.config.Audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.id != "" {
+		token.Header["kid"] = key.id
+	}
+	return token.SignedString(key.private)
+}
+
+// ValidateToken validates a JWT access token and returns the claims
 func ValidateToken(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != "" {
+		return nil, errors.New("invalid token")
+	}
+
+	revoked, err := IsTokenRevoked(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("checking token revocation: %w", err)
+	}
+	if revoked {
+		return nil, errors.New("token has been revoked")
+	}
+
+	if claims.SessionID != "" {
+		active, err := IsSessionActive(claims.SessionID)
+		if err != nil {
+			return nil, fmt.Errorf("checking session: %w", err)
+		}
+		if !active {
+			return nil, errors.New("session has been revoked")
+		}
+	}
+
+	return claims, nil
+}
+
+// ValidateMFAChallenge validates a token issued by GenerateMFAChallenge
+func ValidateMFAChallenge(tokenString string) (*Claims, error) {
+	claims, err := parseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Purpose != PurposeMFAChallenge {
+		return nil, errors.New("invalid MFA token")
+	}
+	revoked, err := IsTokenRevoked(claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("checking token revocation: %w", err)
+	}
+	if revoked {
+		return nil, errors.New("invalid MFA token")
+	}
+
+	return claims, nil
+}
+
+func parseToken(tokenString string) (*Claims, error) {
+	if keys == nil {
+		return nil, ErrKeysNotInitialized
+	}
+
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+		kid, _ := token.Header["kid"].(string)
+		key, ok := keys.lookup(kid)
+		if !ok || token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unknown signing key")
+		}
+		return key.public, nil
+	},
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}),
+		jwt.WithIssuer(keys.config.Issuer),
+		jwt.WithAudience(keys.config.Audience),
+	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
 	}
 
-	return nil, errors.New("invalid token")
+	return claims, nil
 }
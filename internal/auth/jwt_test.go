@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// memoryRevocations is a RevocationStore for tests; err makes every lookup fail
+type memoryRevocations struct {
+	revoked map[string]bool
+	err     error
+}
+
+func (m *memoryRevocations) Revoke(jti string, expiresAt time.Time) error {
+	m.revoked[jti] = true
+	return m.err
+}
+
+func (m *memoryRevocations) IsRevoked(jti string) (bool, error) {
+	return m.revoked[jti], m.err
+}
+
+func initTestKeys(t *testing.T) {
+	t.Helper()
+	err := InitKeys(KeyConfig{Algorithm: "HS256", Secret: "test-secret", Environment: "test", Issuer: "test", Audience: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func useRevocations(t *testing.T, store RevocationStore) {
+	t.Helper()
+	previous := Revocations
+	Revocations = store
+	t.Cleanup(func() { Revocations = previous })
+}
+
+func TestValidateTokenRevocation(t *testing.T) {
+	initTestKeys(t)
+
+	tests := []struct {
+		name    string
+		store   RevocationStore
+		revoke  bool
+		wantErr bool
+	}{
+		{"not revoked", &memoryRevocations{revoked: map[string]bool{}}, false, false},
+		{"revoked", &memoryRevocations{revoked: map[string]bool{}}, true, true},
+		{"store fails", &memoryRevocations{revoked: map[string]bool{}, err: errors.New("connection refused")}, false, true},
+		{"no store", nil, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRevocations(t, tt.store)
+
+			token, err := GenerateToken(Claims{UserID: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.revoke {
+				claims, err := parseToken(token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := RevokeAccessToken(claims); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := ValidateToken(token); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateToken() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTokenWithoutID(t *testing.T) {
+	initTestKeys(t)
+	useRevocations(t, &memoryRevocations{revoked: map[string]bool{}})
+
+	key, err := keys.signer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.NewWithClaims(key.method, Claims{
+		UserID: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "test",
+			Audience:  jwt.ClaimStrings{"test"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}).SignedString(key.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A token without an ID could never be revoked
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("token without an ID accepted")
+	}
+}
+
+// memorySessions is a SessionStore for tests; err makes every check fail
+type memorySessions struct {
+	active map[string]bool
+	err    error
+}
+
+func (m *memorySessions) IsActive(id string) (bool, error) {
+	return m.active[id], m.err
+}
+
+func TestValidateTokenSession(t *testing.T) {
+	initTestKeys(t)
+	useRevocations(t, &memoryRevocations{revoked: map[string]bool{}})
+
+	tests := []struct {
+		name    string
+		store   SessionStore
+		wantErr bool
+	}{
+		{"active", &memorySessions{active: map[string]bool{"session": true}}, false},
+		{"revoked", &memorySessions{active: map[string]bool{}}, true},
+		{"store fails", &memorySessions{active: map[string]bool{"session": true}, err: errors.New("connection refused")}, true},
+		{"no store", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := Sessions
+			Sessions = tt.store
+			defer func() { Sessions = previous }()
+
+			token, err := GenerateToken(Claims{UserID: 1, SessionID: "session"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := ValidateToken(token); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateToken() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// defaultSecret is the development-only HS256 secret used when JWT_SECRET is unset
+const defaultSecret = "your-secret-key-change-this-in-production"
+
+// keyRotationLock is the Postgres advisory lock serializing rotation across instances
+const keyRotationLock = 727001
+
+var ErrKeysNotInitialized = errors.New("signing keys have not been initialized")
+
+// KeyConfig configures how tokens are signed and verified
+type KeyConfig struct {
+	Algorithm        string // RS256, EdDSA or HS256
+	Secret           string // HS256 only
+	Environment      string
+	Issuer           string
+	Audience         string
+	RotationInterval time.Duration
+}
+
+type signingKey struct {
+	id        string
+	method    jwt.SigningMethod
+	private   interface{}
+	public    interface{}
+	createdAt time.Time
+	retiresAt time.Time
+}
+
+type keySet struct {
+	mu         sync.RWMutex
+	config     KeyConfig
+	current    *signingKey
+	keys       map[string]*signingKey
+	lastReload time.Time
+}
+
+// keys holds the active key set; it is nil until InitKeys is called
+var keys *keySet
+
+// InitKeys loads or creates the signing keys. HS256 with the default secret is
+// refused outside development mode.
+func InitKeys(config KeyConfig) error {
+	ks := &keySet{config: config, keys: map[string]*signingKey{}}
+
+	switch config.Algorithm {
+	case "HS256":
+		if config.Secret == "" || config.Secret == defaultSecret {
+			if config.Environment != "development" {
+				return errors.New("JWT_SECRET must be set to a non-default value outside development mode")
+			}
+			log.Println("WARNING: JWT_SECRET not set, using default (insecure for production)")
+			config.Secret = defaultSecret
+		}
+		ks.current = &signingKey{
+			method:  jwt.SigningMethodHS256,
+			private: []byte(config.Secret),
+			public:  []byte(config.Secret),
+		}
+		// HS256 tokens carry no key ID
+		ks.keys[""] = ks.current
+	case "RS256", "EdDSA":
+		if err := ks.reload(); err != nil {
+			return err
+		}
+		if ks.needsRotation(time.Now()) {
+			if err := ks.rotate(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q", config.Algorithm)
+	}
+
+	keys = ks
+	return nil
+}
+
+// StartKeyRotation periodically picks up keys created by other instances and
+// rotates the signing key once it is older than the rotation interval
+func StartKeyRotation() {
+	if keys == nil || keys.config.Algorithm == "HS256" {
+		return
+	}
+
+	for range time.Tick(10 * time.Minute) {
+		if err := keys.reload(); err != nil {
+			log.Println("Failed to reload signing keys:", err)
+			continue
+		}
+		if keys.needsRotation(time.Now()) {
+			if err := keys.rotate(); err != nil {
+				log.Println("Failed to rotate signing key:", err)
+			}
+		}
+	}
+}
+
+// tokenLifetime is the longest a signed token can remain valid, and therefore
+// how long a retired key must remain available for verification
+func tokenLifetime() time.Duration {
+	lifetime := AccessTokenTTL
+	for _, ttl := range []time.Duration{MFAChallengeTTL, MaxImpersonationTTL} {
+		if ttl > lifetime {
+			lifetime = ttl
+		}
+	}
+	return lifetime
+}
+
+func (ks *keySet) needsRotation(now time.Time) bool {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.current == nil || now.Sub(ks.current.createdAt) >= ks.config.RotationInterval
+}
+
+// reload replaces the in-memory key set with the unexpired keys in the database
+func (ks *keySet) reload() error {
+	var rows []models.SigningKey
+	if err := database.DB.Where("expires_at > ?", time.Now()).Order("created_at ASC").Find(&rows).Error; err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	loaded := map[string]*signingKey{}
+	var current *signingKey
+	for _, row := range rows {
+		key, err := decodeSigningKey(row)
+		if err != nil {
+			return err
+		}
+		loaded[key.id] = key
+		if row.Algorithm == ks.config.Algorithm && key.retiresAt.After(time.Now()) {
+			current = key
+		}
+	}
+
+	ks.mu.Lock()
+	ks.keys = loaded
+	ks.current = current
+	ks.lastReload = time.Now()
+	ks.mu.Unlock()
+	return nil
+}
+
+// rotate generates a new signing key and retires the previous one. Retired keys
+// keep verifying until every token they signed has expired.
+func (ks *keySet) rotate() error {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", keyRotationLock).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		// Another instance may have rotated while we waited for the lock
+		var latest models.SigningKey
+		err := tx.Where("algorithm = ? AND retires_at > ?", ks.config.Algorithm, now).Order("created_at DESC").First(&latest).Error
+		if err == nil && now.Sub(latest.CreatedAt) < ks.config.RotationInterval {
+			return nil
+		}
+
+		row, err := generateSigningKey(ks.config.Algorithm)
+		if err != nil {
+			return err
+		}
+		row.CreatedAt = now
+		row.RetiresAt = now.Add(2 * ks.config.RotationInterval)
+		row.ExpiresAt = row.RetiresAt.Add(tokenLifetime())
+
+		if err := tx.Model(&models.SigningKey{}).Where("retires_at > ?", now).
+			Updates(map[string]interface{}{"retires_at": now, "expires_at": now.Add(tokenLifetime())}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("expires_at < ?", now).Delete(&models.SigningKey{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&row).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to rotate signing key: %w", err)
+	}
+
+	log.Println("Signing key rotated")
+	return ks.reload()
+}
+
+// lookup finds a verification key by ID, reloading once if another instance
+// may have introduced it since the last reload
+func (ks *keySet) lookup(kid string) (*signingKey, bool) {
+	ks.mu.RLock()
+	key, ok := ks.keys[kid]
+	stale := time.Since(ks.lastReload) > 30*time.Second
+	ks.mu.RUnlock()
+
+	if ok || !stale || ks.config.Algorithm == "HS256" {
+		return key, ok
+	}
+
+	if err := ks.reload(); err != nil {
+		return nil, false
+	}
+
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok = ks.keys[kid]
+	return key, ok
+}
+
+func (ks *keySet) signer() (*signingKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if ks.current == nil {
+		return nil, ErrKeysNotInitialized
+	}
+	return ks.current, nil
+}
+
+func generateSigningKey(algorithm string) (models.SigningKey, error) {
+	var private crypto.Signer
+	var err error
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		err = fmt.Errorf("unsupported JWT algorithm %q", algorithm)
+	}
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return models.SigningKey{}, err
+	}
+
+	return models.SigningKey{
+		ID:         newRandomToken(12),
+		Algorithm:  algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+	}, nil
+}
+
+func decodeSigningKey(row models.SigningKey) (*signingKey, error) {
+	block, _ := pem.Decode([]byte(row.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not valid PEM", row.ID)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", row.ID, err)
+	}
+
+	key := &signingKey{id: row.ID, private: parsed, createdAt: row.CreatedAt, retiresAt: row.RetiresAt}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.public = jwt.SigningMethodRS256, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k.Public()
+	default:
+		return nil, fmt.Errorf("signing key %s has unsupported type %T", row.ID, parsed)
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns every public key that may still verify an unexpired token.
+// It is empty when tokens are signed with a shared HS256 secret.
+func PublicJWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	if keys == nil {
+		return set
+	}
+
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+	for _, key := range keys.keys {
+		jwk := JWK{KeyID: key.id, Use: "sig", Algorithm: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+)
+
+const recoveryCodeCount = 10
+
+// MFAChallengeTTL is how long a user has to complete the second login step
+var MFAChallengeTTL = 5 * time.Minute
+
+// normalizeRecoveryCode makes recovery codes case and separator insensitive
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// ReplaceRecoveryCodes discards the user's recovery codes and returns a fresh set.
+// Only hashes are stored, so the codes must be shown to the user now.
+func ReplaceRecoveryCodes(userID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := range codes {
+			raw := strings.ToLower(base32NoPadding.EncodeToString(randomBytes(7)))[:10]
+			codes[i] = raw[:5] + "-" + raw[5:]
+			code := models.RecoveryCode{UserID: userID, CodeHash: hashToken(normalizeRecoveryCode(raw))}
+			if err := tx.Create(&code).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes, reporting whether it was valid
+func UseRecoveryCode(userID int, code string) bool {
+	result := database.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	return result.Error == nil && result.RowsAffected == 1
+}
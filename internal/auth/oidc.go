@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm/clause"
+)
+
+// OIDCStateTTL is how long a user has to complete an external login
+const OIDCStateTTL = 10 * time.Minute
+
+var ErrInvalidOIDCState = errors.New("invalid or expired login state")
+
+// NewOIDCLoginState starts an external login, returning the state, nonce and
+// PKCE code verifier to send to the provider
+func NewOIDCLoginState(provider string) (state, nonce, verifier string, err error) {
+	state, nonce, verifier = newRandomToken(32), newRandomToken(32), newRandomToken(48)
+
+	row := models.OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		ExpiresAt:    time.Now().Add(OIDCStateTTL),
+	}
+	if err := database.DB.Create(&row).Error; err != nil {
+		return "", "", "", err
+	}
+	return state, nonce, verifier, nil
+}
+
+// ConsumeOIDCLoginState deletes and returns the login state for a callback, so
+// each state can complete at most one login
+func ConsumeOIDCLoginState(state, provider string) (models.OIDCLoginState, error) {
+	var rows []models.OIDCLoginState
+	result := database.DB.Clauses(clause.Returning{}).
+		Where("state_hash = ?", hashToken(state)).
+		Delete(&rows)
+	if result.Error != nil {
+		return models.OIDCLoginState{}, result.Error
+	}
+	if len(rows) == 0 || rows[0].Provider != provider || time.Now().After(rows[0].ExpiresAt) {
+		return models.OIDCLoginState{}, ErrInvalidOIDCState
+	}
+	return rows[0], nil
+}
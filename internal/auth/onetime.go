@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+// Purposes of single-use tokens sent by email
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeAccountUnlock     = "account_unlock"
+	PurposeEmailChange       = "email_change"
+)
+
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// NewOneTimeToken creates a single-use token for the user and invalidates any
+// earlier unused token with the same purpose
+func NewOneTimeToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	if err := database.DB.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error; err != nil {
+		return "", err
+	}
+
+	raw := newRandomToken(32)
+	token := models.UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(raw),
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := database.DB.Create(&token).Error; err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ConsumeOneTimeToken marks the token as used and returns the user it was issued to
+func ConsumeOneTimeToken(raw, purpose string) (int, error) {
+	var token models.UserToken
+	if err := database.DB.Where("token_hash = ? AND purpose = ?", hashToken(raw), purpose).First(&token).Error; err != nil {
+		return 0, ErrInvalidOneTimeToken
+	}
+
+	// The conditional update makes concurrent redemptions of the same token race safely
+	result := database.DB.Model(&models.UserToken{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", token.ID, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, ErrInvalidOneTimeToken
+	}
+	return token.UserID, nil
+}
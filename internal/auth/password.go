@@ -1,15 +1,130 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
-// HashPassword hashes a plain text password
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashes are stored in a self-describing format so the algorithm and
+// its parameters can change without invalidating existing hashes: bcrypt in its
+// standard "$2a$<cost>$..." form and argon2id in the PHC string format
+// "$argon2id$v=19$m=<KiB>,t=<passes>,p=<threads>$<salt>$<hash>".
+
+// HashConfig selects the algorithm and cost used for new password hashes
+type HashConfig struct {
+	Algorithm     string // argon2id or bcrypt
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32 // KiB
+	Argon2Threads uint8
+}
+
+// DefaultHashConfig follows the OWASP recommendation for argon2id
+var DefaultHashConfig = HashConfig{
+	Algorithm:     "argon2id",
+	BcryptCost:    14,
+	Argon2Time:    2,
+	Argon2Memory:  19 * 1024,
+	Argon2Threads: 1,
+}
+
+var hashConfig = DefaultHashConfig
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// ConfigurePasswordHashing sets the algorithm used for new hashes. Hashes made
+// with other settings keep verifying and are upgraded on the next login.
+func ConfigurePasswordHashing(config HashConfig) error {
+	switch config.Algorithm {
+	case "argon2id":
+		if config.Argon2Time < 1 || config.Argon2Memory < 8*uint32(config.Argon2Threads) || config.Argon2Threads < 1 {
+			return errors.New("invalid argon2id parameters")
+		}
+	case "bcrypt":
+		if config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return fmt.Errorf("unsupported password hash algorithm %q", config.Algorithm)
+	}
+	hashConfig = config
+	return nil
+}
+
+// HashPassword hashes a plain text password with the configured algorithm
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
+	if hashConfig.Algorithm == "bcrypt" {
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), hashConfig.BcryptCost)
+		return string(bytes), err
+	}
+
+	salt := randomBytes(argon2SaltLength)
+	key := argon2.IDKey([]byte(password), salt, hashConfig.Argon2Time, hashConfig.Argon2Memory, hashConfig.Argon2Threads, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, hashConfig.Argon2Memory, hashConfig.Argon2Time, hashConfig.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// CheckPassword compares a hashed password with a plain text password.
+// needsRehash reports that the hash was made with outdated settings and should
+// be replaced with HashPassword now that the plain text is known.
+func CheckPassword(hashedPassword, password string) (ok, needsRehash bool) {
+	if strings.HasPrefix(hashedPassword, "$argon2id$") {
+		return checkArgon2(hashedPassword, password)
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return true, err != nil || hashConfig.Algorithm != "bcrypt" || cost != hashConfig.BcryptCost
+}
+
+func checkArgon2(hashedPassword, password string) (ok, needsRehash bool) {
+	var version int
+	var memory, time uint32
+	var threads uint8
+
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, false
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(actual, key) != 1 {
+		return false, false
+	}
+
+	outdated := hashConfig.Algorithm != "argon2id" ||
+		memory != hashConfig.Argon2Memory || time != hashConfig.Argon2Time || threads != hashConfig.Argon2Threads
+	return true, outdated
 }
 
-// CheckPassword compares a hashed password with a plain text password
-func CheckPassword(hashedPassword, password string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+// NewRandomPassword returns an unguessable password for accounts created
+// without one, such as those signing in through an external provider
+func NewRandomPassword() string {
+	return newRandomToken(32)
 }
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy is the strength policy new passwords must satisfy
+type PasswordPolicy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// passwordPolicy is applied by ValidatePassword
+var passwordPolicy = PasswordPolicy{MinLength: 8, MaxLength: 128}
+
+var ErrPasswordBreached = errors.New("password appears in a list of breached passwords; choose a different one")
+
+// ConfigurePasswordPolicy sets the length limits and, if breachedListPath is
+// not empty, loads a file of known breached passwords, one per line
+func ConfigurePasswordPolicy(minLength, maxLength int, breachedListPath string) error {
+	if minLength < 1 || maxLength < minLength {
+		return errors.New("invalid password length limits")
+	}
+
+	policy := PasswordPolicy{MinLength: minLength, MaxLength: maxLength}
+	if breachedListPath != "" {
+		breached, err := loadBreachedPasswords(breachedListPath)
+		if err != nil {
+			return err
+		}
+		policy.breached = breached
+	}
+
+	passwordPolicy = policy
+	return nil
+}
+
+func loadBreachedPasswords(path string) (map[string]struct{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	defer file.Close()
+
+	breached := map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			breached[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached password list: %w", err)
+	}
+	return breached, nil
+}
+
+// ValidatePassword checks a new password against the password policy
+func ValidatePassword(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < passwordPolicy.MinLength {
+		return fmt.Errorf("password must be at least %d characters", passwordPolicy.MinLength)
+	}
+	if length > passwordPolicy.MaxLength {
+		return fmt.Errorf("password must be at most %d characters", passwordPolicy.MaxLength)
+	}
+	// bcrypt ignores everything after the first 72 bytes
+	if hashConfig.Algorithm == "bcrypt" && len(password) > 72 {
+		return errors.New("password must be at most 72 bytes")
+	}
+	if _, ok := passwordPolicy.breached[strings.ToLower(password)]; ok {
+		return ErrPasswordBreached
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permissions checked by the router. Suffixes distinguish a user's own records
+// (own), those of a seller's products (seller) and every record (any).
+const (
+	PermCartWrite         = "cart:write"
+	PermCartReadAny       = "cart:read:any"
+	PermOrderCreate       = "order:create"
+	PermOrderReadOwn      = "order:read:own"
+	PermOrderReadSeller   = "order:read:seller"
+	PermOrderReadAny      = "order:read:any"
+	PermOrderFulfill      = "order:fulfill"
+	PermProductReadOwn    = "product:read:own"
+	PermProductWrite      = "product:write"
+	PermProductStock      = "product:stock"
+	PermProductDeleteAny  = "product:delete:any"
+	PermStatsReadSeller   = "stats:read:seller"
+	PermStatsReadPlatform = "stats:read:platform"
+	PermUserRead          = "user:read"
+	PermUserWrite         = "user:write"
+	PermRoleWrite         = "role:write"
+	PermUserImpersonate   = "user:impersonate"
+	PermAuditRead         = "audit:read"
+	PermCategoryWrite     = "category:write"
+	PermReviewModerate    = "review:moderate"
+)
+
+// AllPermissions lists every permission known to the application
+var AllPermissions = []string{
+	PermCartWrite, PermCartReadAny,
+	PermOrderCreate, PermOrderReadOwn, PermOrderReadSeller, PermOrderReadAny, PermOrderFulfill,
+	PermProductReadOwn, PermProductWrite, PermProductStock, PermProductDeleteAny, PermCategoryWrite,
+	PermReviewModerate,
+	PermStatsReadSeller, PermStatsReadPlatform,
+	PermUserRead, PermUserWrite, PermRoleWrite, PermUserImpersonate, PermAuditRead,
+}
+
+// DefaultRoles are created on first start. Administrators may change the
+// permissions of any role afterwards, except admin which always has every permission.
+var DefaultRoles = map[string][]string{
+	"buyer": {PermCartWrite, PermOrderCreate, PermOrderReadOwn},
+	"seller": {
+		PermProductReadOwn, PermProductWrite, PermProductStock,
+		PermOrderReadSeller, PermOrderFulfill, PermStatsReadSeller,
+	},
+	"support": {PermUserRead, PermCartReadAny, PermOrderReadAny, PermUserImpersonate},
+	"admin":   AllPermissions,
+}
+
+// SeedRoles creates missing permissions and default roles, and gives users
+// created before multiple roles existed a role matching their legacy role column
+func SeedRoles() error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		for _, name := range AllPermissions {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&models.Permission{Name: name}).Error; err != nil {
+				return err
+			}
+		}
+
+		for name, perms := range DefaultRoles {
+			var role models.Role
+			err := tx.Where("name = ?", name).First(&role).Error
+			if err == nil && name != "admin" {
+				continue
+			}
+			if err != nil {
+				role = models.Role{Name: name}
+				if err := tx.Create(&role).Error; err != nil {
+					return err
+				}
+			}
+			if err := setRolePermissions(tx, &role, perms); err != nil {
+				return err
+			}
+		}
+
+		// Backfill from the legacy single role column; sellers could always buy too
+		if err := tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT users.id, roles.id FROM users JOIN roles ON roles.name = users.role
+			WHERE NOT EXISTS (SELECT 1 FROM user_roles WHERE user_roles.user_id = users.id)`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`
+			INSERT INTO user_roles (user_id, role_id)
+			SELECT users.id, roles.id FROM users JOIN roles ON roles.name = 'buyer'
+			WHERE users.role = 'seller'
+			ON CONFLICT DO NOTHING`).Error
+	})
+}
+
+func setRolePermissions(tx *gorm.DB, role *models.Role, names []string) error {
+	var perms []models.Permission
+	if err := tx.Where("name IN ?", names).Find(&perms).Error; err != nil {
+		return err
+	}
+	if len(perms) != len(names) {
+		return fmt.Errorf("unknown permission in %v", names)
+	}
+	return tx.Model(role).Association("Permissions").Replace(perms)
+}
+
+// SetRolePermissions replaces the permissions granted by a role
+func SetRolePermissions(role *models.Role, names []string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		return setRolePermissions(tx, role, names)
+	})
+}
+
+// AssignRoles replaces a user's roles with the named roles. The first role
+// becomes the user's primary role, reported to clients for display.
+func AssignRoles(tx *gorm.DB, user *models.User, names ...string) error {
+	names = unique(names)
+
+	var roles []models.Role
+	if err := tx.Where("name IN ?", names).Find(&roles).Error; err != nil {
+		return err
+	}
+	if len(roles) != len(names) || len(names) == 0 {
+		return fmt.Errorf("unknown role in %v", names)
+	}
+
+	if err := tx.Model(user).Association("Roles").Replace(roles); err != nil {
+		return err
+	}
+	user.Role = names[0]
+	return tx.Model(user).Update("role", names[0]).Error
+}
+
+// LoadRoles returns the names of a user's roles and the union of their permissions
+func LoadRoles(userID int) (roles []string, permissions []string, err error) {
+	var user models.User
+	if err := database.DB.Preload("Roles.Permissions").First(&user, userID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	seen := map[string]bool{}
+	for _, role := range user.Roles {
+		roles = append(roles, role.Name)
+		for _, perm := range role.Permissions {
+			if !seen[perm.Name] {
+				seen[perm.Name] = true
+				permissions = append(permissions, perm.Name)
+			}
+		}
+	}
+	return roles, permissions, nil
+}
+
+// HasPermission reports whether the claims grant the permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, p := range c.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRole reports whether the claims include the role
+func (c *Claims) HasRole(role string) bool {
+	if c.Role == role {
+		return true
+	}
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// unique removes duplicates from names, keeping the first occurrence of each
+func unique(names []string) []string {
+	seen := map[string]bool{}
+	out := names[:0:0]
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+	return out
+}
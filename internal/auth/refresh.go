@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RefreshTokenTTL is how long a refresh token stays valid, loaded from REFRESH_TOKEN_TTL
+var RefreshTokenTTL = getDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// randomBytes returns n bytes from the system's secure random source
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return b
+}
+
+// newRandomToken returns n random bytes encoded as URL-safe base64
+func newRandomToken(n int) string {
+	return base64.RawURLEncoding.EncodeToString(randomBytes(n))
+}
+
+// hashToken returns the hex SHA-256 digest stored in place of an opaque token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IssueRefreshToken starts a new session for the user and returns its ID and
+// first refresh token. mfa records whether the login completed a second factor.
+func IssueRefreshToken(userID int, mfa bool, client ClientInfo) (sessionID, raw string, err error) {
+	sessionID = newRandomToken(16)
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := createSession(tx, sessionID, userID, client); err != nil {
+			return err
+		}
+		_, raw, err = createRefreshToken(tx, userID, sessionID, mfa)
+		return err
+	})
+	return sessionID, raw, err
+}
+
+func createRefreshToken(tx *gorm.DB, userID int, familyID string, mfa bool) (models.RefreshToken, string, error) {
+	raw := newRandomToken(32)
+	token := models.RefreshToken{
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: hashToken(raw),
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := tx.Create(&token).Error; err != nil {
+		return models.RefreshToken{}, "", err
+	}
+	return token, raw, nil
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same family
+// and returns the new token's record, whose FamilyID is the session ID. Presenting
+// a token that was already rotated revokes the whole family.
+func RotateRefreshToken(raw string, client ClientInfo) (next models.RefreshToken, newRaw string, err error) {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var token models.RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(raw)).First(&token).Error; err != nil {
+			return ErrInvalidRefreshToken
+		}
+
+		if token.RevokedAt != nil {
+			return ErrRefreshTokenReused
+		}
+
+		if time.Now().After(token.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		now := time.Now()
+		if err := tx.Model(&token).Update("revoked_at", &now).Error; err != nil {
+			return err
+		}
+
+		if err := refreshSession(tx, token.FamilyID, client); err != nil {
+			return err
+		}
+
+		next, newRaw, err = createRefreshToken(tx, token.UserID, token.FamilyID, token.MFA)
+		return err
+	})
+
+	// The family is revoked outside the transaction, which is rolled back on error
+	if errors.Is(err, ErrRefreshTokenReused) {
+		revokeFamilyOf(raw)
+	}
+	return next, newRaw, err
+}
+
+// RevokeRefreshToken revokes the family the given refresh token belongs to,
+// provided it was issued to userID
+func RevokeRefreshToken(userID int, raw string) error {
+	var token models.RefreshToken
+	if err := database.DB.Where("token_hash = ? AND user_id = ?", hashToken(raw), userID).First(&token).Error; err != nil {
+		return ErrInvalidRefreshToken
+	}
+	return revokeFamily(database.DB, token.FamilyID)
+}
+
+// RevokeAllRefreshTokens revokes every refresh token and session of the user
+func RevokeAllRefreshTokens(userID int) error {
+	return RevokeOtherSessions(userID, "")
+}
+
+func revokeFamilyOf(raw string) {
+	var token models.RefreshToken
+	if err := database.DB.Where("token_hash = ?", hashToken(raw)).First(&token).Error; err == nil {
+		revokeFamily(database.DB, token.FamilyID)
+	}
+}
+
+// revokeFamily revokes a refresh token family and the session it belongs to
+func revokeFamily(tx *gorm.DB, familyID string) error {
+	if err := tx.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevocationStore keeps the IDs of revoked access tokens until they expire
+type RevocationStore interface {
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// Revocations is where access tokens are revoked. It defaults to the database;
+// tests can replace it.
+var Revocations RevocationStore = dbRevocations{}
+
+var errNoRevocationStore = errors.New("token revocation store is not configured")
+
+// dbRevocations keeps revoked tokens in the revoked_tokens table
+type dbRevocations struct{}
+
+func (dbRevocations) Revoke(jti string, expiresAt time.Time) error {
+	if database.DB == nil {
+		return errNoRevocationStore
+	}
+	revoked := models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}
+	return database.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&revoked).Error
+}
+
+func (dbRevocations) IsRevoked(jti string) (bool, error) {
+	if database.DB == nil {
+		return false, errNoRevocationStore
+	}
+	var count int64
+	if err := database.DB.Model(&models.RevokedToken{}).Where("jti = ?", jti).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// RevokeAccessToken adds an access token to the revocation list until it expires
+func RevokeAccessToken(claims *Claims) error {
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return nil
+	}
+	if Revocations == nil {
+		return errNoRevocationStore
+	}
+	return Revocations.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
+
+// IsTokenRevoked reports whether the access token with the given ID was revoked.
+// A token without an ID cannot be revoked, so it is refused with an error, as is
+// every token when the store is missing or fails. Callers must reject the token
+// on error.
+func IsTokenRevoked(jti string) (bool, error) {
+	if jti == "" {
+		return false, errors.New("token has no ID")
+	}
+	if Revocations == nil {
+		return false, errNoRevocationStore
+	}
+	return Revocations.IsRevoked(jti)
+}
+
+// PurgeExpiredTokens removes refresh tokens, sessions, revocation entries and
+// external login states that can no longer be used
+func PurgeExpiredTokens() error {
+	now := time.Now()
+	if err := database.DB.Where("expires_at < ?", now).Delete(&models.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	if err := database.DB.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		return err
+	}
+	if err := database.DB.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+	return database.DB.Where("expires_at < ?", now).Delete(&models.RefreshToken{}).Error
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written for busy sessions
+const sessionTouchInterval = time.Minute
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo describes the device a session was started or refreshed from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
+	Device    string
+}
+
+func createSession(tx *gorm.DB, id string, userID int, client ClientInfo) error {
+	now := time.Now()
+	return tx.Create(&models.Session{
+		ID:         id,
+		UserID:     userID,
+		Device:     client.Device,
+		IPAddress:  client.IPAddress,
+		UserAgent:  client.UserAgent,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}).Error
+}
+
+// refreshSession records a token refresh, extending the session's lifetime
+func refreshSession(tx *gorm.DB, id string, client ClientInfo) error {
+	now := time.Now()
+	return tx.Model(&models.Session{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"ip_address":   client.IPAddress,
+		"user_agent":   client.UserAgent,
+		"last_seen_at": now,
+		"expires_at":   now.Add(RefreshTokenTTL),
+	}).Error
+}
+
+// SessionStore reports whether the session an access token belongs to is still active
+type SessionStore interface {
+	IsActive(id string) (bool, error)
+}
+
+// Sessions is where access tokens' sessions are checked. It defaults to the
+// database; tests can replace it.
+var Sessions SessionStore = dbSessions{}
+
+var errNoSessionStore = errors.New("session store is not configured")
+
+// dbSessions checks sessions in the sessions table
+type dbSessions struct{}
+
+// IsActive records that the session was seen when it is active
+func (dbSessions) IsActive(id string) (bool, error) {
+	if database.DB == nil {
+		return false, errNoSessionStore
+	}
+
+	var session models.Session
+	err := database.DB.Where("id = ?", id).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return false, nil
+	}
+
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		database.DB.Model(&session).Update("last_seen_at", now)
+	}
+	return true, nil
+}
+
+// IsSessionActive reports whether the session exists and has not been revoked
+// or expired. Callers must reject the token when the check fails.
+func IsSessionActive(id string) (bool, error) {
+	if Sessions == nil {
+		return false, errNoSessionStore
+	}
+	return Sessions.IsActive(id)
+}
+
+// ListSessions returns the user's active sessions, most recently used first
+func ListSessions(userID int) ([]models.Session, error) {
+	var sessions []models.Session
+	err := database.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession logs the user out of one session, invalidating its access and
+// refresh tokens
+func RevokeSession(userID int, id string) error {
+	result := database.DB.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return revokeFamily(database.DB, id)
+}
+
+// RevokeOtherSessions logs the user out everywhere except the session keep
+func RevokeOtherSessions(userID int, keep string) error {
+	return database.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keep).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND family_id <> ? AND revoked_at IS NULL", userID, keep).
+			Update("revoked_at", now).Error
+	})
+}
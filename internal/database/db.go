@@ -43,16 +43,40 @@ func Connect(config Config) error {
 // Migrate runs database migrations to create/update all tables
 func Migrate() error {
 	err := DB.AutoMigrate(
+		&models.Permission{},
+		&models.Role{},
 		&models.User{},
+		&models.Category{},
 		&models.Product{},
+		&models.ProductOption{},
+		&models.ProductVariant{},
+		&models.ProductImage{},
+		&models.Review{},
+		&models.ReviewReport{},
 		&models.CartItem{},
 		&models.Order{},
 		&models.OrderItem{},
+		&models.RefreshToken{},
+		&models.RevokedToken{},
+		&models.UserToken{},
+		&models.RecoveryCode{},
+		&models.SigningKey{},
+		&models.LoginAttempt{},
+		&models.APIKey{},
+		&models.UserIdentity{},
+		&models.OIDCLoginState{},
+		&models.Session{},
+		&models.Job{},
+		&models.AuditLog{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := runSQLMigrations(); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+
 	log.Println("Database migrated successfully")
 	return nil
 }
@@ -0,0 +1,28 @@
+package database
+
+import "fmt"
+
+// sqlMigrations holds schema changes AutoMigrate cannot express. Each statement
+// must be idempotent as they all run on every start, in order.
+var sqlMigrations = []string{
+	// Full-text search: name matches outrank description matches
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS search_vector tsvector
+		GENERATED ALWAYS AS (
+			setweight(to_tsvector('english', coalesce(name, '')), 'A') ||
+			setweight(to_tsvector('english', coalesce(description, '')), 'B')
+		) STORED`,
+	`CREATE INDEX IF NOT EXISTS idx_products_search_vector ON products USING GIN (search_vector)`,
+
+	// Trigram matching tolerates misspelled product names
+	`CREATE EXTENSION IF NOT EXISTS pg_trgm`,
+	`CREATE INDEX IF NOT EXISTS idx_products_name_trgm ON products USING GIN (name gin_trgm_ops)`,
+}
+
+func runSQLMigrations() error {
+	for i, statement := range sqlMigrations {
+		if err := DB.Exec(statement).Error; err != nil {
+			return fmt.Errorf("sql migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
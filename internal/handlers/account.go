@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/mailer"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+type UpdateProfileRequest struct {
+	Name *string `json:"name"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type PasswordRequest struct {
+	Password string `json:"password"`
+}
+
+// GetMe returns the current user's profile
+func GetMe(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var user models.User
+	if err := database.DB.Preload("Roles").First(&user, claims.UserID).Error; err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateMe changes the current user's profile. Email and password have their
+// own endpoints because they need the current password.
+func UpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			sendError(w, "Name cannot be empty", http.StatusBadRequest)
+			return
+		}
+		if err := database.DB.Model(&user).Update("name", name).Error; err != nil {
+			sendError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	GetMe(w, r)
+}
+
+// checkCurrentPassword verifies a password re-entered to confirm a sensitive
+// change, counting failures like failed logins. It writes the error response
+// and returns false when the password is wrong or the account is throttled.
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, user models.User, password string) bool {
+	attempt, ok := beginLogin(w, r, user.Email)
+	if !ok {
+		return false
+	}
+	if ok, _ := auth.CheckPassword(user.Password, password); !ok {
+		attempt.fail(&user)
+		sendError(w, "Current password is incorrect", http.StatusUnauthorized)
+		return false
+	}
+	attempt.succeed()
+	return true
+}
+
+// ChangePassword sets a new password and signs the user out of their other sessions
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		sendError(w, "Current and new password are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, req.CurrentPassword) {
+		return
+	}
+
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		sendError(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("password", hashedPassword).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	claims := middleware.GetUserFromContext(r)
+	if err := auth.RevokeOtherSessions(user.ID, claims.SessionID); err != nil {
+		log.Printf("Failed to revoke sessions for user %d: %v", user.ID, err)
+	}
+
+	err = Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nThe password for your account was just changed. If this wasn't you, reset your password immediately.\n", user.Name),
+	})
+	if err != nil {
+		log.Printf("Failed to send password change notice to user %d: %v", user.ID, err)
+	}
+
+	sendMessage(w, "Password changed", http.StatusOK)
+}
+
+// ChangeEmail starts an email change. The new address takes effect once the
+// link sent to it is opened.
+func ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email == "" || req.Password == "" {
+		sendError(w, "Email and password are required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if strings.EqualFold(req.Email, user.Email) {
+		sendError(w, "This is already your email address", http.StatusBadRequest)
+		return
+	}
+
+	var count int64
+	database.DB.Unscoped().Model(&models.User{}).Where("LOWER(email) = LOWER(?)", req.Email).Count(&count)
+	if count > 0 {
+		sendError(w, "Email already registered", http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("pending_email", req.Email).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, err := auth.NewOneTimeToken(user.ID, auth.PurposeEmailChange, emailChangeTTL)
+	if err != nil {
+		sendError(w, "Failed to create confirmation token", http.StatusInternalServerError)
+		return
+	}
+
+	err = Mailer.Send(mailer.Message{
+		To:      req.Email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to use this address for your account:\n\n%s/api/auth/confirm-email-change?token=%s\n\nThe link expires in %s.\n",
+			user.Name, AppURL, token, emailChangeTTL),
+	})
+	if err != nil {
+		sendError(w, "Failed to send confirmation email", http.StatusInternalServerError)
+		return
+	}
+
+	sendMessage(w, "Check your new email address to confirm the change", http.StatusAccepted)
+}
+
+// ConfirmEmailChange switches the user to their pending email address. The
+// token may be sent as a query parameter or in a JSON body.
+func ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var req TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		sendError(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.ConsumeOneTimeToken(token, auth.PurposeEmailChange)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil || user.PendingEmail == "" {
+		sendError(w, "No email change is pending", http.StatusBadRequest)
+		return
+	}
+
+	oldEmail := user.Email
+	user.Email = user.PendingEmail
+
+	// The unique index rejects the change if the address was taken meanwhile
+	err = database.DB.Model(&user).Updates(map[string]interface{}{
+		"email":             user.Email,
+		"pending_email":     "",
+		"email_verified_at": time.Now(),
+	}).Error
+	if err != nil {
+		sendError(w, "Email already registered", http.StatusConflict)
+		return
+	}
+
+	err = Mailer.Send(mailer.Message{
+		To:      oldEmail,
+		Subject: "Your email address was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nYour account email was changed to %s. If this wasn't you, contact support immediately.\n", user.Name, user.Email),
+	})
+	if err != nil {
+		log.Printf("Failed to send email change notice to user %d: %v", user.ID, err)
+	}
+
+	sendMessage(w, "Email address changed", http.StatusOK)
+}
+
+// DeleteAccount closes the current user's account. The user row is anonymized
+// rather than deleted so past orders keep a valid customer.
+func DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	var req PasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Password == "" {
+		sendError(w, "Password is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !checkCurrentPassword(w, r, user, req.Password) {
+		return
+	}
+
+	if middleware.GetUserFromContext(r).HasRole("admin") {
+		sendError(w, "Administrators must be demoted before closing their account", http.StatusConflict)
+		return
+	}
+
+	if err := anonymizeUser(user.ID); err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// anonymizeUser removes a user's personal data and credentials while keeping
+// the row referenced by their orders. Products they sold are removed, or
+// archived when orders refer to them. Deleted users are anonymized too, and
+// their deleted rows are removed for good. Their sessions go with the rest of
+// their data, which rejects their access tokens, and so do their export archives.
+func anonymizeUser(userID int) error {
+	var removedImages []models.ProductImage
+	err := database.DB.Unscoped().Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		attemptsKey := accountKey(user.Email)
+		now := time.Now()
+		password, err := auth.HashPassword(auth.NewRandomPassword())
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(&user).Updates(map[string]interface{}{
+			"email":             fmt.Sprintf("deleted-user-%d@invalid", user.ID),
+			"name":              "Deleted user",
+			"password":          password,
+			"pending_email":     "",
+			"email_verified_at": nil,
+			"mfa_enabled":       false,
+			"totp_secret":       "",
+			"anonymized_at":     now,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user).Association("Roles").Clear(); err != nil {
+			return err
+		}
+
+		// Sessions hold IP addresses and user agents
+		for _, model := range []interface{}{
+			&models.CartItem{}, &models.RecoveryCode{}, &models.UserToken{}, &models.UserIdentity{}, &models.ReviewReport{},
+			&models.RefreshToken{}, &models.Session{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+
+		// Failed login attempts are keyed by the email address
+		if err := tx.Where("key = ?", attemptsKey).Delete(&models.LoginAttempt{}).Error; err != nil {
+			return err
+		}
+
+		// Reviews are personal content; removing them also removes their ratings
+		var reviewed []int
+		if err := tx.Model(&models.Review{}).Where("user_id = ?", user.ID).Pluck("product_id", &reviewed).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Review{}).Error; err != nil {
+			return err
+		}
+		for _, productID := range reviewed {
+			if err := syncProductRating(tx, productID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&models.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", user.ID).
+			Update("revoked_at", now).Error; err != nil {
+			return err
+		}
+
+		// Products still referenced by order items are kept for order history
+		sold := tx.Model(&models.OrderItem{}).Select("product_id")
+		owned := tx.Model(&models.Product{}).Select("id").Where("seller_id = ?", user.ID)
+		if err := tx.Where("product_id IN (?)", owned).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Product{}).Where("seller_id = ? AND id IN (?)", user.ID, sold).
+			Updates(map[string]interface{}{"stock": 0, "status": ProductArchived}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.ProductVariant{}).Where("product_id IN (?)", owned).
+			Update("stock", 0).Error; err != nil {
+			return err
+		}
+		unsold := tx.Model(&models.Product{}).Select("id").Where("seller_id = ? AND id NOT IN (?)", user.ID, sold)
+		if err := tx.Where("product_id IN (?)", unsold).Find(&removedImages).Error; err != nil {
+			return err
+		}
+		return tx.Where("seller_id = ? AND id NOT IN (?)", user.ID, sold).Delete(&models.Product{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	deleteImageFiles(context.Background(), removedImages)
+
+	suggestions.Invalidate()
+	return removeUserExports(userID)
+}
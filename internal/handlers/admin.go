@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// AdminDeleteProduct soft-deletes any product regardless of seller, along with
+// cart items referencing it. Products that appear in orders are archived instead.
+func AdminDeleteProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var orderItems int64
+	database.DB.Model(&models.OrderItem{}).Where("product_id = ?", productID).Count(&orderItems)
+	if orderItems > 0 {
+		var product models.Product
+		if err := database.DB.First(&product, productID).Error; err != nil {
+			http.Error(w, "Product not found", http.StatusNotFound)
+			return
+		}
+		if err := setProductStatus(database.DB, &product, ProductArchived); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		suggestions.Invalidate()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(product)
+		return
+	}
+
+	var deleted int64
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", productID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		result := tx.Delete(&models.Product{}, productID)
+		deleted = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if deleted == 0 {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminGetOrders returns orders across all users, optionally filtered by
+// user_id and status, paginated
+func AdminGetOrders(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.Order{})
+
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("user_id = ?", id)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	var orders []models.Order
+	result := query.Preload("User", withDeleted).Preload("OrderItems.Product", withDeleted).Preload("OrderItems.Variant").
+		Order("created_at DESC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Find(&orders)
+
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orders)
+}
+
+// GetPlatformStats returns platform-wide statistics for the admin dashboard
+func GetPlatformStats(w http.ResponseWriter, r *http.Request) {
+	var stats struct {
+		TotalUsers          int64            `json:"total_users"`
+		UsersByRole         map[string]int64 `json:"users_by_role"`
+		SuspendedUsers      int64            `json:"suspended_users"`
+		TotalProducts       int64            `json:"total_products"`
+		OutOfStockProducts  int64            `json:"out_of_stock_products"`
+		TotalOrders         int64            `json:"total_orders"`
+		OrdersLast30Days    int64            `json:"orders_last_30_days"`
+		PendingOrderItems   int64            `json:"pending_order_items"`
+		CompletedOrderItems int64            `json:"completed_order_items"`
+		TotalRevenue        float64          `json:"total_revenue"`
+	}
+
+	database.DB.Model(&models.User{}).Count(&stats.TotalUsers)
+	database.DB.Model(&models.User{}).Where("suspended_at IS NOT NULL").Count(&stats.SuspendedUsers)
+
+	var roles []struct {
+		Role  string
+		Count int64
+	}
+	database.DB.Table("user_roles").
+		Select("roles.name AS role, COUNT(*) AS count").
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Group("roles.name").
+		Scan(&roles)
+	stats.UsersByRole = map[string]int64{}
+	for _, role := range roles {
+		stats.UsersByRole[role.Role] = role.Count
+	}
+
+	database.DB.Model(&models.Product{}).Count(&stats.TotalProducts)
+	database.DB.Model(&models.Product{}).Where("stock <= 0").Count(&stats.OutOfStockProducts)
+
+	database.DB.Model(&models.Order{}).Count(&stats.TotalOrders)
+	database.DB.Model(&models.Order{}).Where("created_at >= ?", time.Now().AddDate(0, 0, -30)).Count(&stats.OrdersLast30Days)
+
+	database.DB.Model(&models.OrderItem{}).Where("status = ?", "pending").Count(&stats.PendingOrderItems)
+	database.DB.Model(&models.OrderItem{}).Where("status = ?", "completed").Count(&stats.CompletedOrderItems)
+
+	// Revenue counts completed order items only, matching the seller dashboard
+	database.DB.Table("order_items").
+		Select("COALESCE(SUM(price * quantity), 0)").
+		Where("status = ?", "completed").
+		Scan(&stats.TotalRevenue)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetRoles lists every role with its permissions
+func GetRoles(w http.ResponseWriter, r *http.Request) {
+	var roles []models.Role
+	result := database.DB.Preload("Permissions").Order("name ASC").Find(&roles)
+
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roles)
+}
+
+// CreateRole adds a new role with the given permissions
+func CreateRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string   `json:"name"`
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Role name is required", http.StatusBadRequest)
+		return
+	}
+
+	role := models.Role{Name: req.Name}
+	if err := database.DB.Create(&role).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err := auth.SetRolePermissions(&role, req.Permissions); err != nil {
+		database.DB.Delete(&role)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database.DB.Preload("Permissions").First(&role, role.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(role)
+}
+
+// UpdateRolePermissions replaces the permissions granted by a role. The admin
+// role always keeps every permission.
+func UpdateRolePermissions(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if name == "admin" {
+		http.Error(w, "The admin role cannot be changed", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var role models.Role
+	if err := database.DB.Where("name = ?", name).First(&role).Error; err != nil {
+		http.Error(w, "Role not found", http.StatusNotFound)
+		return
+	}
+
+	if err := auth.SetRolePermissions(&role, req.Permissions); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database.DB.Preload("Permissions").First(&role, role.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(role)
+}
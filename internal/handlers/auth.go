@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
 	"net/http"
 
 	"github.com/MdHisham-04/E-Commerce/internal/auth"
 	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
 	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
 )
 
 type ErrorResponse struct {
@@ -32,16 +35,111 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 type AuthResponse struct {
-	Token string       `json:"token"`
-	User  UserResponse `json:"user"`
+	Token        string       `json:"token"`
+	RefreshToken string       `json:"refresh_token"`
+	User         UserResponse `json:"user"`
 }
 
 type UserResponse struct {
-	ID    int    `json:"id"`
-	Email string `json:"email"`
-	Name  string `json:"name"`
-	Role  string `json:"role"`
+	ID    int      `json:"id"`
+	Email string   `json:"email"`
+	Name  string   `json:"name"`
+	Role  string   `json:"role"`
+	Roles []string `json:"roles"`
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+// accessClaims builds the access token claims for a user's session, embedding
+// the permissions granted by their roles
+func accessClaims(user models.User, mfa bool, sessionID string) (auth.Claims, error) {
+	roles, permissions, err := auth.LoadRoles(user.ID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	return auth.Claims{
+		UserID:      user.ID,
+		Email:       user.Email,
+		Role:        user.Role,
+		Roles:       roles,
+		Permissions: permissions,
+		MFA:         mfa,
+		SessionID:   sessionID,
+	}, nil
+}
+
+// issueTokens starts a session on the requesting device and returns its first
+// access/refresh token pair
+func issueTokens(r *http.Request, user models.User, mfa bool) (AuthResponse, error) {
+	sessionID, refreshToken, err := auth.IssueRefreshToken(user.ID, mfa, clientInfo(r))
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	claims, err := accessClaims(user, mfa, sessionID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	token, err := auth.GenerateToken(claims)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	return newAuthResponse(user, claims.Roles, token, refreshToken), nil
+}
+
+// completeLogin finishes a successful first-factor login, either issuing tokens
+// or, when the user has MFA enabled, a challenge for the second step
+func completeLogin(w http.ResponseWriter, r *http.Request, user models.User) {
+	if user.SuspendedAt != nil {
+		sendError(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if user.MFAEnabled {
+		mfaToken, err := auth.GenerateMFAChallenge(user.ID)
+		if err != nil {
+			sendError(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(MFAChallengeResponse{MFARequired: true, MFAToken: mfaToken})
+		return
+	}
+
+	response, err := issueTokens(r, user, false)
+	if err != nil {
+		sendError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// newAuthResponse builds the response returned by every endpoint that hands out tokens
+func newAuthResponse(user models.User, roles []string, token, refreshToken string) AuthResponse {
+	return AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		User: UserResponse{
+			ID:    user.ID,
+			Email: user.Email,
+			Name:  user.Name,
+			Role:  user.Role,
+			Roles: roles,
+		},
+	}
 }
 
 // Register creates a new user account
@@ -57,12 +155,20 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Role != "buyer" && req.Role != "seller" {
-		req.Role = "buyer"
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Sellers can also shop, so they hold both roles
+	roles := []string{"buyer"}
+	if req.Role == "seller" {
+		roles = []string{"seller", "buyer"}
 	}
 
+	// Deleted accounts keep their email until they are purged, so they can be restored
 	var existingUser models.User
-	if err := database.DB.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := database.DB.Unscoped().Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
 		sendError(w, "Email already registered", http.StatusConflict)
 		return
 	}
@@ -77,28 +183,32 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		Email:    req.Email,
 		Name:     req.Name,
 		Password: hashedPassword,
-		Role:     req.Role,
 	}
 
-	if err := database.DB.Create(&user).Error; err != nil {
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return auth.AssignRoles(tx, &user, roles...)
+	})
+	if err != nil {
 		sendError(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role)
-	if err != nil {
-		sendError(w, "Failed to generate token", http.StatusInternalServerError)
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	if RequireEmailVerification {
+		sendMessage(w, "Account created. Check your email to verify your address before logging in", http.StatusCreated)
 		return
 	}
 
-	response := AuthResponse{
-		Token: token,
-		User: UserResponse{
-			ID:    user.ID,
-			Email: user.Email,
-			Name:  user.Name,
-			Role:  user.Role,
-		},
+	response, err := issueTokens(r, user, false)
+	if err != nil {
+		sendError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -119,33 +229,135 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Throttling happens before any password hashing so failed attempts stay cheap
+	attempt, ok := beginLogin(w, r, req.Email)
+	if !ok {
+		return
+	}
+
 	var user models.User
 	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		attempt.fail(nil)
 		sendError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	if !auth.CheckPassword(user.Password, req.Password) {
+	valid, needsRehash := auth.CheckPassword(user.Password, req.Password)
+	if !valid {
+		attempt.fail(&user)
 		sendError(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	token, err := auth.GenerateToken(user.ID, user.Email, user.Role)
+	if needsRehash {
+		rehashPassword(user, req.Password)
+	}
+
+	attempt.succeed()
+
+	if RequireEmailVerification && user.EmailVerifiedAt == nil {
+		sendError(w, "Email address has not been verified", http.StatusForbidden)
+		return
+	}
+
+	completeLogin(w, r, user)
+}
+
+// rehashPassword upgrades a password hash made with outdated settings. Failure
+// is not fatal since the old hash still verifies.
+func rehashPassword(user models.User, password string) {
+	hashedPassword, err := auth.HashPassword(password)
+	if err == nil {
+		// Only replace the hash that was verified, in case the password changed meanwhile
+		err = database.DB.Model(&models.User{}).
+			Where("id = ? AND password = ?", user.ID, user.Password).
+			Update("password", hashedPassword).Error
+	}
+	if err != nil {
+		log.Printf("Failed to rehash password for user %d: %v", user.ID, err)
+	}
+}
+
+// Refresh exchanges a refresh token for a new access/refresh token pair
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		sendError(w, "Refresh token is required", http.StatusBadRequest)
+		return
+	}
+
+	next, refreshToken, err := auth.RotateRefreshToken(req.RefreshToken, clientInfo(r))
+	if err != nil {
+		sendError(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, next.UserID).Error; err != nil {
+		sendError(w, "User not found", http.StatusUnauthorized)
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		sendError(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	claims, err := accessClaims(user, next.MFA, next.FamilyID)
 	if err != nil {
 		sendError(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	response := AuthResponse{
-		Token: token,
-		User: UserResponse{
-			ID:    user.ID,
-			Email: user.Email,
-			Name:  user.Name,
-			Role:  user.Role,
-		},
+	token, err := auth.GenerateToken(claims)
+	if err != nil {
+		sendError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(newAuthResponse(user, claims.Roles, token, refreshToken))
+}
+
+// Logout revokes the caller's access token and session and, if supplied, the
+// session of the given refresh token
+func Logout(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var req RefreshRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := auth.RevokeAccessToken(claims); err != nil {
+		sendError(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	if claims.SessionID != "" {
+		if err := auth.RevokeSession(claims.UserID, claims.SessionID); err != nil && err != auth.ErrSessionNotFound {
+			sendError(w, "Failed to revoke session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if req.RefreshToken != "" {
+		if err := auth.RevokeRefreshToken(claims.UserID, req.RefreshToken); err != nil {
+			sendError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JWKS publishes the public keys that verify access tokens so other services can
+// validate them without sharing a secret
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(auth.PublicJWKS())
 }
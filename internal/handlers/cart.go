@@ -12,8 +12,9 @@ import (
 )
 
 type AddToCartRequest struct {
-	ProductID int `json:"product_id"`
-	Quantity  int `json:"quantity"`
+	ProductID int  `json:"product_id"`
+	VariantID *int `json:"variant_id"`
+	Quantity  int  `json:"quantity"`
 }
 
 // GetCart returns all items in a user's cart
@@ -26,7 +27,7 @@ func GetCart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var cartItems []models.CartItem
-	result := database.DB.Preload("Product").Where("user_id = ?", userID).Find(&cartItems)
+	result := database.DB.Preload("Product").Preload("Variant").Where("user_id = ?", userID).Find(&cartItems)
 
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
@@ -52,26 +53,46 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Check if product exists and has enough stock
+	// Check if product is on sale and has enough stock
 	var product models.Product
-	if err := database.DB.First(&product, req.ProductID).Error; err != nil {
+	if err := database.DB.Scopes(publishedProducts).Preload("Variants").First(&product, req.ProductID).Error; err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
 
-	if product.Stock < req.Quantity {
+	// Products with variants are bought by variant, each with its own stock
+	stock := product.Stock
+	if len(product.Variants) > 0 {
+		variant := findVariant(product.Variants, req.VariantID)
+		if variant == nil {
+			http.Error(w, "Choose a variant of this product", http.StatusBadRequest)
+			return
+		}
+		stock = variant.Stock
+	} else if req.VariantID != nil {
+		http.Error(w, "Product has no variants", http.StatusBadRequest)
+		return
+	}
+
+	if stock < req.Quantity {
 		http.Error(w, "Insufficient stock", http.StatusBadRequest)
 		return
 	}
 
 	// Check if item already in cart
 	var existingItem models.CartItem
-	result := database.DB.Where("user_id = ? AND product_id = ?", userID, req.ProductID).First(&existingItem)
+	query := database.DB.Where("user_id = ? AND product_id = ?", userID, req.ProductID)
+	if req.VariantID != nil {
+		query = query.Where("variant_id = ?", *req.VariantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+	result := query.First(&existingItem)
 
 	if result.Error == nil {
 		// Update quantity - but check total doesn't exceed stock
 		newQuantity := existingItem.Quantity + req.Quantity
-		if product.Stock < newQuantity {
+		if stock < newQuantity {
 			http.Error(w, "Insufficient stock", http.StatusBadRequest)
 			return
 		}
@@ -80,7 +101,7 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 		database.DB.Save(&existingItem)
 
 		// Reload with product data
-		database.DB.Preload("Product").First(&existingItem, existingItem.ID)
+		database.DB.Preload("Product").Preload("Variant").First(&existingItem, existingItem.ID)
 
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(existingItem)
@@ -91,6 +112,7 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 	cartItem := models.CartItem{
 		UserID:    userID,
 		ProductID: req.ProductID,
+		VariantID: req.VariantID,
 		Quantity:  req.Quantity,
 	}
 
@@ -100,13 +122,25 @@ func AddToCart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Load product relation
-	database.DB.Preload("Product").First(&cartItem, cartItem.ID)
+	database.DB.Preload("Product").Preload("Variant").First(&cartItem, cartItem.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(cartItem)
 }
 
+func findVariant(variants []models.ProductVariant, id *int) *models.ProductVariant {
+	if id == nil {
+		return nil
+	}
+	for i := range variants {
+		if variants[i].ID == *id {
+			return &variants[i]
+		}
+	}
+	return nil
+}
+
 // RemoveFromCart removes an item from the cart
 func RemoveFromCart(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
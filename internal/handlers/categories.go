@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// categorySubtree selects the IDs of a category and all its descendants. The
+// %s is the column identifying the root, id or slug. UNION rather than UNION
+// ALL stops the recursion even if the tree were to contain a cycle.
+const categorySubtree = `WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE %s = ?
+		UNION
+		SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+	) SELECT id FROM subtree`
+
+// categoryProductCounts counts the distinct published products in each category
+// and its descendants
+const categoryProductCounts = `WITH RECURSIVE ancestry AS (
+		SELECT id, id AS ancestor_id FROM categories
+		UNION
+		SELECT ancestry.id, categories.parent_id FROM ancestry
+		JOIN categories ON categories.id = ancestry.ancestor_id
+		WHERE categories.parent_id IS NOT NULL
+	)
+	SELECT ancestry.ancestor_id AS category_id, COUNT(DISTINCT product_categories.product_id) AS count
+	FROM ancestry JOIN product_categories ON product_categories.category_id = ancestry.id
+	JOIN products ON products.id = product_categories.product_id AND products.status = 'published'
+		AND products.deleted_at IS NULL AND ` + sellerNotDeleted + `
+	GROUP BY ancestry.ancestor_id`
+
+type CategoryRequest struct {
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *int   `json:"parent_id"`
+	Position int    `json:"position"`
+}
+
+type CategoryNode struct {
+	models.Category
+	ProductCount int64           `json:"product_count"`
+	Children     []*CategoryNode `json:"children"`
+}
+
+type ProductCategoriesRequest struct {
+	CategoryIDs []int `json:"category_ids"`
+}
+
+// categoryFilter restricts a product query to a category, given by ID or slug,
+// and its descendants
+func categoryFilter(query *gorm.DB, category string) *gorm.DB {
+	column := "slug"
+	if _, err := strconv.Atoi(category); err == nil {
+		column = "id"
+	}
+	return query.Where("products.id IN (SELECT product_id FROM product_categories WHERE category_id IN ("+
+		fmt.Sprintf(categorySubtree, column)+"))", category)
+}
+
+// slugify turns a category name into a URL-friendly slug
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// GetCategories returns the category tree. Each category's product count
+// includes the products of its descendants.
+func GetCategories(w http.ResponseWriter, r *http.Request) {
+	var categories []models.Category
+	if err := database.DB.Order("position ASC, name ASC").Find(&categories).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var counts []struct {
+		CategoryID int
+		Count      int64
+	}
+	if err := database.DB.Raw(categoryProductCounts).Scan(&counts).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	nodes := make(map[int]*CategoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &CategoryNode{Category: category, Children: []*CategoryNode{}}
+	}
+	for _, count := range counts {
+		if node, ok := nodes[count.CategoryID]; ok {
+			node.ProductCount = count.Count
+		}
+	}
+
+	// categories is sorted, so appending in order keeps siblings sorted
+	roots := []*CategoryNode{}
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if category.ParentID != nil {
+			if parent, ok := nodes[*category.ParentID]; ok {
+				parent.Children = append(parent.Children, node)
+				continue
+			}
+		}
+		roots = append(roots, node)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(roots)
+}
+
+// decodeCategory reads and validates a category from the request body. id is
+// the category being updated, or 0 when creating one. It writes the error
+// response and returns false when the request is invalid.
+func decodeCategory(w http.ResponseWriter, r *http.Request, id int) (models.Category, bool) {
+	var req CategoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return models.Category{}, false
+	}
+
+	category := models.Category{
+		ID:       id,
+		Name:     strings.TrimSpace(req.Name),
+		Slug:     slugify(req.Slug),
+		ParentID: req.ParentID,
+		Position: req.Position,
+	}
+	if category.Name == "" {
+		http.Error(w, "Category name is required", http.StatusBadRequest)
+		return category, false
+	}
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	if category.Slug == "" {
+		http.Error(w, "Category slug must contain letters or digits", http.StatusBadRequest)
+		return category, false
+	}
+	if _, err := strconv.Atoi(category.Slug); err == nil {
+		// Numeric slugs would be mistaken for IDs when filtering products
+		http.Error(w, "Category slug cannot be a number", http.StatusBadRequest)
+		return category, false
+	}
+
+	var count int64
+	database.DB.Model(&models.Category{}).Where("slug = ? AND id <> ?", category.Slug, id).Count(&count)
+	if count > 0 {
+		http.Error(w, "Category slug already in use", http.StatusConflict)
+		return category, false
+	}
+
+	if category.ParentID != nil {
+		if err := database.DB.First(&models.Category{}, *category.ParentID).Error; err != nil {
+			http.Error(w, "Parent category not found", http.StatusBadRequest)
+			return category, false
+		}
+		if id != 0 {
+			database.DB.Raw("SELECT COUNT(*) FROM ("+fmt.Sprintf(categorySubtree, "id")+") subtree WHERE id = ?",
+				id, *category.ParentID).Scan(&count)
+			if count > 0 {
+				http.Error(w, "A category cannot be moved under itself or its descendants", http.StatusBadRequest)
+				return category, false
+			}
+		}
+	}
+
+	return category, true
+}
+
+// CreateCategory adds a category, at the top level unless parent_id is given.
+// The slug is derived from the name when omitted.
+func CreateCategory(w http.ResponseWriter, r *http.Request) {
+	category, ok := decodeCategory(w, r, 0)
+	if !ok {
+		return
+	}
+
+	if err := database.DB.Create(&category).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(category)
+}
+
+// UpdateCategory replaces a category's name, slug, parent and position
+func UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var existing models.Category
+	if err := database.DB.First(&existing, id).Error; err != nil {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	category, ok := decodeCategory(w, r, id)
+	if !ok {
+		return
+	}
+	category.CreatedAt = existing.CreatedAt
+
+	if err := database.DB.Save(&category).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(category)
+}
+
+// DeleteCategory removes a category that has no subcategories. Its products
+// stay listed under their other categories.
+func DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var children int64
+	database.DB.Model(&models.Category{}).Where("parent_id = ?", id).Count(&children)
+	if children > 0 {
+		http.Error(w, "Move or delete the subcategories first", http.StatusConflict)
+		return
+	}
+
+	result := database.DB.Delete(&models.Category{}, id)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		http.Error(w, "Category not found", http.StatusNotFound)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replaceCategories sets the categories of a product, removing any others
+func replaceCategories(db *gorm.DB, product *models.Product, categories []models.Category) error {
+	association := db.Model(product).Association("Categories")
+	if len(categories) == 0 {
+		return association.Clear()
+	}
+	return association.Replace(categories)
+}
+
+// SetProductCategories replaces the categories of a seller's product
+func SetProductCategories(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ProductCategoriesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Where("id = ? AND seller_id = ?", productID, claims.UserID).First(&product).Error; err != nil {
+		http.Error(w, "Product not found or access denied", http.StatusNotFound)
+		return
+	}
+
+	categories := []models.Category{}
+	if len(req.CategoryIDs) > 0 {
+		if err := database.DB.Where("id IN ?", req.CategoryIDs).Find(&categories).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	unique := map[int]bool{}
+	for _, id := range req.CategoryIDs {
+		unique[id] = true
+	}
+	if len(categories) != len(unique) {
+		http.Error(w, "Unknown category ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := replaceCategories(database.DB, &product, categories); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/lockout"
+	"github.com/MdHisham-04/E-Commerce/internal/mailer"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc"
+	"github.com/MdHisham-04/E-Commerce/internal/storage"
+)
+
+// Settings below are configured once at startup from the environment
+
+// Mailer delivers account emails such as password reset links
+var Mailer mailer.Mailer = &mailer.MemoryMailer{}
+
+// AppURL is the public base URL used to build links sent by email
+var AppURL = "http://localhost:8080"
+
+// RequireEmailVerification blocks login until the user has verified their email address
+var RequireEmailVerification = false
+
+// MFAIssuer is the account issuer shown in authenticator apps
+var MFAIssuer = "E-Commerce"
+
+// ExportDir is where personal data export archives are written
+var ExportDir = "./exports"
+
+// ExportTTL is how long a personal data export stays available for download
+var ExportTTL = 7 * 24 * time.Hour
+
+// DeletedRetention is how long deleted products, users and cart items can be
+// restored before they are purged
+var DeletedRetention = 30 * 24 * time.Hour
+
+// Storage holds uploaded product images
+var Storage storage.Storage = &storage.LocalStorage{Dir: "./uploads", BaseURL: "/uploads"}
+
+// MaxImageBytes is the largest image file sellers may upload
+var MaxImageBytes int64 = 10 << 20
+
+// MaxImportBytes is the largest product import file sellers may upload
+var MaxImportBytes int64 = 5 << 20
+
+// ReviewReportThreshold is how many reports hold a published review for moderation
+var ReviewReportThreshold = 3
+
+// ReviewModerator returns the status of a new or edited review, so reviews can
+// be screened before they appear, for example for links or banned words. The
+// default publishes every review.
+var ReviewModerator = func(review models.Review) string { return ReviewPublished }
+
+// OIDCProviders are the external identity providers users can sign in with, by name
+var OIDCProviders = map[string]*oidc.Provider{}
+
+// AccountPolicy throttles and locks out repeated failed logins for one account
+var AccountPolicy = lockout.Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Second,
+	MaxDelay:         5 * time.Minute,
+	LockoutThreshold: 10,
+	LockoutDuration:  30 * time.Minute,
+	Window:           24 * time.Hour,
+}
+
+// IPPolicy throttles repeated failed logins from one IP address across accounts
+var IPPolicy = lockout.Policy{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     15 * time.Minute,
+	Window:       time.Hour,
+}
+
+// AccountLimiter and IPLimiter apply the policies above to failed logins
+var (
+	AccountLimiter = lockout.New(lockout.NewMemoryStore(), AccountPolicy)
+	IPLimiter      = lockout.New(lockout.NewMemoryStore(), IPPolicy)
+)
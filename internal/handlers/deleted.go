@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// withDeleted includes soft-deleted rows, for preloading records that order
+// history and other kept records still refer to
+func withDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped()
+}
+
+// onlyDeleted restricts a query to soft-deleted rows
+func onlyDeleted(db *gorm.DB) *gorm.DB {
+	return db.Unscoped().Where("deleted_at IS NOT NULL")
+}
+
+// writeDeleted responds with a page of soft-deleted records, most recently
+// deleted first
+func writeDeleted(w http.ResponseWriter, r *http.Request, query *gorm.DB, records interface{}, scopes ...func(*gorm.DB) *gorm.DB) {
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	result := query.Scopes(scopes...).Order("deleted_at DESC, id DESC").Offset((page - 1) * perPage).Limit(perPage).Find(records)
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(records)
+}
+
+// AdminGetDeletedProducts returns soft-deleted products, optionally filtered by
+// seller_id, paginated
+func AdminGetDeletedProducts(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.Product{}).Scopes(onlyDeleted)
+	if sellerID := r.URL.Query().Get("seller_id"); sellerID != "" {
+		id, err := strconv.Atoi(sellerID)
+		if err != nil {
+			http.Error(w, "Invalid seller ID", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("seller_id = ?", id)
+	}
+
+	var products []models.Product
+	writeDeleted(w, r, query, &products)
+}
+
+// AdminRestoreProduct brings back a soft-deleted product with the status it had
+func AdminRestoreProduct(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Scopes(onlyDeleted).First(&product, id).Error; err != nil {
+		http.Error(w, "Deleted product not found", http.StatusNotFound)
+		return
+	}
+
+	if err := database.DB.Unscoped().Model(&product).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// AdminGetDeletedUsers returns soft-deleted users, paginated
+func AdminGetDeletedUsers(w http.ResponseWriter, r *http.Request) {
+	var users []models.User
+	writeDeleted(w, r, database.DB.Model(&models.User{}).Scopes(onlyDeleted), &users)
+}
+
+// AdminDeleteUser soft-deletes a user, along with their cart and the cart items
+// of their products, and revokes their sessions, which also rejects the access
+// tokens already issued for them. Their products are no longer listed.
+func AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if claims := middleware.GetUserFromContext(r); claims.UserID == id {
+		http.Error(w, "You cannot delete your own account here", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		owned := tx.Model(&models.Product{}).Select("id").Where("seller_id = ?", user.ID)
+		if err := tx.Where("user_id = ? OR product_id IN (?)", user.ID, owned).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&user).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
+	if err := auth.RevokeAllRefreshTokens(user.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminRestoreUser brings back a soft-deleted user. Users whose personal data
+// has already been purged cannot be restored.
+func AdminRestoreUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.Scopes(onlyDeleted).First(&user, id).Error; err != nil {
+		http.Error(w, "Deleted user not found", http.StatusNotFound)
+		return
+	}
+	if user.AnonymizedAt != nil {
+		http.Error(w, "User has been purged and cannot be restored", http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// AdminGetDeletedCartItems returns soft-deleted cart items, whether removed by
+// the user, ordered or taken off sale, optionally filtered by user_id, paginated
+func AdminGetDeletedCartItems(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.CartItem{}).Scopes(onlyDeleted)
+	if userID := r.URL.Query().Get("user_id"); userID != "" {
+		id, err := strconv.Atoi(userID)
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		query = query.Where("user_id = ?", id)
+	}
+
+	var cartItems []models.CartItem
+	writeDeleted(w, r, query, &cartItems, func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Product", withDeleted).Preload("Variant")
+	})
+}
+
+// AdminRestoreCartItem puts a soft-deleted item back in its user's cart, as
+// long as the product is still on sale and the cart does not already hold it
+func AdminRestoreCartItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid cart item ID", http.StatusBadRequest)
+		return
+	}
+
+	var cartItem models.CartItem
+	if err := database.DB.Scopes(onlyDeleted).First(&cartItem, id).Error; err != nil {
+		http.Error(w, "Deleted cart item not found", http.StatusNotFound)
+		return
+	}
+
+	var available int64
+	database.DB.Model(&models.Product{}).Scopes(publishedProducts).Where("products.id = ?", cartItem.ProductID).Count(&available)
+	if available == 0 {
+		http.Error(w, "Product is no longer available", http.StatusConflict)
+		return
+	}
+
+	var inCart int64
+	query := database.DB.Model(&models.CartItem{}).Where("user_id = ? AND product_id = ?", cartItem.UserID, cartItem.ProductID)
+	if cartItem.VariantID != nil {
+		query = query.Where("variant_id = ?", *cartItem.VariantID)
+	} else {
+		query = query.Where("variant_id IS NULL")
+	}
+	query.Count(&inCart)
+	if inCart > 0 {
+		http.Error(w, "The cart already holds this item", http.StatusConflict)
+		return
+	}
+
+	if err := database.DB.Unscoped().Model(&cartItem).Update("deleted_at", nil).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	database.DB.Preload("Product").Preload("Variant").First(&cartItem, cartItem.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cartItem)
+}
+
+// PurgeDeletedRecords permanently removes cart items and products deleted more
+// than DeletedRetention ago, along with the images of those products. Users
+// deleted as long ago are anonymized instead, as their orders still refer to them.
+func PurgeDeletedRecords() error {
+	cutoff := time.Now().Add(-DeletedRetention)
+
+	var productImages []models.ProductImage
+	err := database.DB.Unscoped().Transaction(func(tx *gorm.DB) error {
+		// Ordered products are archived rather than deleted; the check keeps
+		// order history intact should one have been deleted anyway
+		expired := tx.Model(&models.Product{}).Select("id").
+			Where("deleted_at < ? AND id NOT IN (?)", cutoff, tx.Model(&models.OrderItem{}).Select("product_id"))
+
+		if err := tx.Where("deleted_at < ? OR product_id IN (?)", cutoff, expired).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("product_id IN (?)", expired).Find(&productImages).Error; err != nil {
+			return err
+		}
+		return tx.Where("id IN (?)", expired).Delete(&models.Product{}).Error
+	})
+	if err != nil {
+		return err
+	}
+	deleteImageFiles(context.Background(), productImages)
+
+	var userIDs []int
+	if err := database.DB.Model(&models.User{}).Unscoped().
+		Where("deleted_at < ? AND anonymized_at IS NULL", cutoff).Pluck("id", &userIDs).Error; err != nil {
+		return err
+	}
+	for _, id := range userIDs {
+		if err := anonymizeUser(id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
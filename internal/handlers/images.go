@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/images"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// maxProductImages limits how many images a product can have
+const maxProductImages = 10
+
+type ImageOrderRequest struct {
+	ImageIDs []int `json:"image_ids"`
+}
+
+// orderedImages preloads product images in display order
+func orderedImages(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC, id ASC")
+}
+
+// setImageURLs fills in the public URLs of the products' images
+func setImageURLs(products []models.Product) {
+	for i := range products {
+		for j := range products[i].Images {
+			img := &products[i].Images[j]
+			img.URL = Storage.URL(img.Key)
+			img.Thumbnails = make(map[string]string, len(img.ThumbnailKeys))
+			for size, key := range img.ThumbnailKeys {
+				img.Thumbnails[size] = Storage.URL(key)
+			}
+		}
+	}
+}
+
+// deleteImageFiles removes images and their thumbnails from storage. Failures
+// are logged rather than returned as the database rows are already gone.
+func deleteImageFiles(ctx context.Context, productImages []models.ProductImage) {
+	for _, img := range productImages {
+		keys := []string{img.Key}
+		for _, key := range img.ThumbnailKeys {
+			keys = append(keys, key)
+		}
+		for _, key := range keys {
+			if err := Storage.Delete(ctx, key); err != nil {
+				log.Printf("Failed to delete image file %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// UploadProductImage adds an image to a seller's product from the "image" field
+// of a multipart form. The type is detected from the content, and thumbnails
+// are generated in every size in images.ThumbnailSizes. The first image
+// becomes the primary one.
+func UploadProductImage(w http.ResponseWriter, r *http.Request) {
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var count int64
+	database.DB.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Count(&count)
+	if count >= maxProductImages {
+		http.Error(w, fmt.Sprintf("A product can have at most %d images", maxProductImages), http.StatusConflict)
+		return
+	}
+
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImageBytes+64<<10)
+	file, _, err := r.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Image must be at most %d MB", MaxImageBytes>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "An image file is required in the image field", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, MaxImageBytes+1))
+	if err != nil {
+		http.Error(w, "Failed to read image", http.StatusBadRequest)
+		return
+	}
+	if int64(len(data)) > MaxImageBytes {
+		http.Error(w, fmt.Sprintf("Image must be at most %d MB", MaxImageBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	decoded, contentType, err := images.Decode(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	prefix := fmt.Sprintf("products/%d/%s", product.ID, strings.ToLower(rand.Text()))
+	img := models.ProductImage{
+		ProductID:     product.ID,
+		Key:           prefix + "/original." + images.Extension(contentType),
+		ThumbnailKeys: map[string]string{},
+		ContentType:   contentType,
+		Width:         decoded.Bounds().Dx(),
+		Height:        decoded.Bounds().Dy(),
+	}
+
+	if err := Storage.Put(r.Context(), img.Key, data, contentType); err != nil {
+		log.Printf("Failed to store image for product %d: %v", product.ID, err)
+		http.Error(w, "Failed to store image", http.StatusInternalServerError)
+		return
+	}
+
+	for _, size := range images.ThumbnailSizes {
+		key, err := storeThumbnail(r.Context(), decoded, prefix, size)
+		if err != nil {
+			log.Printf("Failed to store %s thumbnail for product %d: %v", size.Name, product.ID, err)
+			deleteImageFiles(context.Background(), []models.ProductImage{img})
+			http.Error(w, "Failed to store image", http.StatusInternalServerError)
+			return
+		}
+		img.ThumbnailKeys[size.Name] = key
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var last models.ProductImage
+		if err := tx.Where("product_id = ?", product.ID).Order("position DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		img.Position = last.Position + 1
+		img.IsPrimary = last.ID == 0
+		return tx.Create(&img).Error
+	})
+	if err != nil {
+		deleteImageFiles(context.Background(), []models.ProductImage{img})
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeProductImages(w, product.ID, http.StatusCreated)
+}
+
+func storeThumbnail(ctx context.Context, original image.Image, prefix string, size images.Size) (string, error) {
+	encoded, contentType, ext, err := images.Encode(images.Thumbnail(original, size.MaxDimension))
+	if err != nil {
+		return "", err
+	}
+	key := prefix + "/" + size.Name + "." + ext
+	return key, Storage.Put(ctx, key, encoded, contentType)
+}
+
+// writeProductImages responds with a product's images in display order
+func writeProductImages(w http.ResponseWriter, productID int, status int) {
+	product := models.Product{ID: productID}
+	database.DB.Preload("Images", orderedImages).First(&product)
+	products := []models.Product{product}
+	setImageURLs(products)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(products[0].Images)
+}
+
+// ReorderProductImages sets the display order of a product's images. The
+// request must list every image of the product exactly once.
+func ReorderProductImages(w http.ResponseWriter, r *http.Request) {
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var req ImageOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var ids []int
+	database.DB.Model(&models.ProductImage{}).Where("product_id = ?", product.ID).Pluck("id", &ids)
+
+	listed := map[int]bool{}
+	for _, id := range req.ImageIDs {
+		listed[id] = true
+	}
+	valid := len(listed) == len(ids) && len(req.ImageIDs) == len(ids)
+	for _, id := range ids {
+		valid = valid && listed[id]
+	}
+	if !valid {
+		http.Error(w, "image_ids must list each of the product's images once", http.StatusBadRequest)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		for position, id := range req.ImageIDs {
+			if err := tx.Model(&models.ProductImage{}).Where("id = ?", id).Update("position", position+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeProductImages(w, product.ID, http.StatusOK)
+}
+
+// ownImage loads an image of one of the authenticated seller's products
+func ownImage(w http.ResponseWriter, r *http.Request) (models.ProductImage, bool) {
+	var img models.ProductImage
+
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return img, false
+	}
+
+	imageID, err := strconv.Atoi(mux.Vars(r)["image_id"])
+	if err != nil {
+		http.Error(w, "Invalid image ID", http.StatusBadRequest)
+		return img, false
+	}
+
+	if err := database.DB.Where("id = ? AND product_id = ?", imageID, product.ID).First(&img).Error; err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return img, false
+	}
+	return img, true
+}
+
+// SetPrimaryImage makes an image the one shown for the product in listings
+func SetPrimaryImage(w http.ResponseWriter, r *http.Request) {
+	img, ok := ownImage(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ProductImage{}).Where("product_id = ? AND id <> ?", img.ProductID, img.ID).
+			Update("is_primary", false).Error; err != nil {
+			return err
+		}
+		return tx.Model(&img).Update("is_primary", true).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeProductImages(w, img.ProductID, http.StatusOK)
+}
+
+// DeleteProductImage removes an image and its files. When the primary image is
+// removed, the next image in display order takes its place.
+func DeleteProductImage(w http.ResponseWriter, r *http.Request) {
+	img, ok := ownImage(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&img).Error; err != nil {
+			return err
+		}
+		if !img.IsPrimary {
+			return nil
+		}
+
+		var next models.ProductImage
+		if err := tx.Where("product_id = ?", img.ProductID).Scopes(orderedImages).Limit(1).Find(&next).Error; err != nil {
+			return err
+		}
+		if next.ID == 0 {
+			return nil
+		}
+		return tx.Model(&next).Update("is_primary", true).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deleteImageFiles(r.Context(), []models.ProductImage{img})
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/audit"
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/mux"
+)
+
+// defaultImpersonationTTL applies when a request does not ask for a duration
+const defaultImpersonationTTL = 15 * time.Minute
+
+type ImpersonateRequest struct {
+	Reason          string `json:"reason"`
+	ReadOnly        *bool  `json:"read_only"`
+	DurationMinutes int    `json:"duration_minutes"`
+}
+
+type ImpersonationResponse struct {
+	Token     string       `json:"token"`
+	TokenID   string       `json:"token_id"`
+	ExpiresAt time.Time    `json:"expires_at"`
+	ReadOnly  bool         `json:"read_only"`
+	User      UserResponse `json:"user"`
+}
+
+// Impersonate issues a short-lived token acting as another user, read-only
+// unless the caller may also modify users. Only users whose permissions the
+// caller also holds can be impersonated. The token is recorded in the audit log.
+func Impersonate(w http.ResponseWriter, r *http.Request) {
+	actor := middleware.GetUserFromContext(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		http.Error(w, "A reason is required", http.StatusBadRequest)
+		return
+	}
+
+	readOnly := req.ReadOnly == nil || *req.ReadOnly
+	if !readOnly && !actor.HasPermission(auth.PermUserWrite) {
+		http.Error(w, "Insufficient permissions for read-write impersonation", http.StatusForbidden)
+		return
+	}
+
+	ttl := defaultImpersonationTTL
+	if req.DurationMinutes > 0 {
+		ttl = time.Duration(req.DurationMinutes) * time.Minute
+	}
+	if ttl > auth.MaxImpersonationTTL {
+		ttl = auth.MaxImpersonationTTL
+	}
+
+	if id == actor.UserID {
+		http.Error(w, "You cannot impersonate yourself", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil || user.AnonymizedAt != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	claims, err := accessClaims(user, false, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Impersonation must not grant rights the actor does not already have
+	for _, permission := range claims.Permissions {
+		if !actor.HasPermission(permission) {
+			http.Error(w, "Cannot impersonate a user with permissions you do not have", http.StatusForbidden)
+			return
+		}
+	}
+
+	token, tokenID, err := auth.GenerateImpersonationToken(claims, actor.UserID, readOnly, ttl)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	err = audit.Record(actor.UserID, user.ID, audit.ActionImpersonationStart, middleware.ClientIP(r), map[string]interface{}{
+		"token_id":   tokenID,
+		"reason":     req.Reason,
+		"read_only":  readOnly,
+		"expires_at": expiresAt,
+	})
+	if err != nil {
+		http.Error(w, "Failed to record audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ImpersonationResponse{
+		Token:     token,
+		TokenID:   tokenID,
+		ExpiresAt: expiresAt,
+		ReadOnly:  readOnly,
+		User:      newAuthResponse(user, claims.Roles, "", "").User,
+	})
+}
+
+// EndImpersonation revokes an impersonation token before it expires
+func EndImpersonation(w http.ResponseWriter, r *http.Request) {
+	actor := middleware.GetUserFromContext(r)
+	tokenID := mux.Vars(r)["token_id"]
+
+	var started models.AuditLog
+	err := database.DB.Where("action = ? AND details::jsonb ->> 'token_id' = ?", audit.ActionImpersonationStart, tokenID).
+		First(&started).Error
+	if err != nil {
+		http.Error(w, "Impersonation not found", http.StatusNotFound)
+		return
+	}
+
+	value, _ := started.Details["expires_at"].(string)
+	expiresAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		http.Error(w, "Invalid audit log entry", http.StatusInternalServerError)
+		return
+	}
+
+	if expiresAt.After(time.Now()) {
+		token := &auth.Claims{}
+		token.ID = tokenID
+		token.ExpiresAt = jwt.NewNumericDate(expiresAt)
+		if err := auth.RevokeAccessToken(token); err != nil {
+			http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	err = audit.Record(actor.UserID, started.SubjectID, audit.ActionImpersonationEnd, middleware.ClientIP(r),
+		map[string]interface{}{"token_id": tokenID, "started_by": started.ActorID})
+	if err != nil {
+		http.Error(w, "Failed to record audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAuditLogs returns audit log entries, newest first, optionally filtered by
+// actor_id, subject_id and action, paginated
+func GetAuditLogs(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.AuditLog{})
+
+	for _, column := range []string{"actor_id", "subject_id"} {
+		if value := r.URL.Query().Get(column); value != "" {
+			id, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, "Invalid "+column, http.StatusBadRequest)
+				return
+			}
+			query = query.Where(column+" = ?", id)
+		}
+	}
+	if action := r.URL.Query().Get("action"); action != "" {
+		query = query.Where("action = ?", action)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	var entries []models.AuditLog
+	if err := query.Order("id DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&entries).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
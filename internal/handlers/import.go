@@ -0,0 +1,690 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/jobs"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobImportProducts is the background job type for seller product imports
+const JobImportProducts = "product_import"
+
+// maxImportRows limits the rows of one import file
+const maxImportRows = 10000
+
+// importProgressEvery is how many rows an import processes between progress updates
+const importProgressEvery = 100
+
+// Actions reported for each row of an import
+const (
+	importCreate  = "create"
+	importUpdate  = "update"
+	importInvalid = "invalid"
+)
+
+// importColumns are the CSV columns, in export order. Categories are given by
+// slug, separated by "|".
+var importColumns = []string{"sku", "name", "description", "price", "stock", "categories"}
+
+// ImportRow is a product or variant in an import or export file. A SKU that
+// belongs to one of the seller's variants updates only its price and stock;
+// any other SKU creates or updates a product. Fields left out of the file are
+// not changed on existing products.
+type ImportRow struct {
+	SKU         string    `json:"sku"`
+	Name        *string   `json:"name,omitempty"`
+	Description *string   `json:"description,omitempty"`
+	Price       *float64  `json:"price,omitempty"`
+	Stock       *int      `json:"stock,omitempty"`
+	Categories  *[]string `json:"categories,omitempty"`
+}
+
+type ImportRowResult struct {
+	Line   int      `json:"line"`
+	SKU    string   `json:"sku,omitempty"`
+	Action string   `json:"action"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// ImportReport describes what an import did, or would do in a dry run, with
+// each row of the file
+type ImportReport struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Invalid int               `json:"invalid"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+type ImportJobResponse struct {
+	models.Job
+	Report *ImportReport `json:"report,omitempty"`
+}
+
+type importPayload struct {
+	Format string `json:"format"`
+	DryRun bool   `json:"dry_run"`
+	Data   string `json:"data"`
+}
+
+// parsedRow is a row read from an import file, or the reason it could not be read
+type parsedRow struct {
+	line int
+	row  ImportRow
+	err  error
+}
+
+// importFormat maps a format name, file extension or media type to csv or
+// ndjson, or returns "" when it is neither
+func importFormat(name string) string {
+	if mediaType, _, err := mime.ParseMediaType(name); err == nil {
+		name = mediaType
+	}
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "csv", "text/csv":
+		return "csv"
+	case "ndjson", "jsonl", "application/x-ndjson", "application/jsonl":
+		return "ndjson"
+	}
+	return ""
+}
+
+// parseImport reads every row of an import file. Errors in single rows are
+// kept with the row; the returned error means the file as a whole is unusable.
+func parseImport(format string, data []byte) ([]parsedRow, error) {
+	var rows []parsedRow
+	var err error
+	switch format {
+	case "csv":
+		rows, err = parseCSV(data)
+	case "ndjson":
+		rows, err = parseNDJSON(data)
+	default:
+		return nil, errors.New("format must be csv or ndjson")
+	}
+	if err == nil && len(rows) == 0 {
+		err = errors.New("file has no rows")
+	}
+	return rows, err
+}
+
+func parseCSV(data []byte) ([]parsedRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(importColumns, name) {
+			return nil, fmt.Errorf("unknown column %q, expected %s", name, strings.Join(importColumns, ", "))
+		}
+		if _, ok := columns[name]; ok {
+			return nil, fmt.Errorf("column %q appears more than once", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, errors.New("the sku column is required")
+	}
+
+	var rows []parsedRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("file has more than %d rows", maxImportRows)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, parseCSVRecord(line, columns, record, len(header)))
+	}
+	return rows, nil
+}
+
+func parseCSVRecord(line int, columns map[string]int, record []string, width int) parsedRow {
+	parsed := parsedRow{line: line}
+	if len(record) != width {
+		parsed.err = fmt.Errorf("expected %d fields, found %d", width, len(record))
+		return parsed
+	}
+
+	field := func(name string) (string, bool) {
+		i, ok := columns[name]
+		if !ok {
+			return "", false
+		}
+		return strings.TrimSpace(record[i]), true
+	}
+
+	row := &parsed.row
+	row.SKU, _ = field("sku")
+	if value, ok := field("name"); ok {
+		row.Name = &value
+	}
+	if value, ok := field("description"); ok {
+		row.Description = &value
+	}
+	// Empty numbers leave the current value, as export does for derived stock
+	if value, ok := field("price"); ok && value != "" {
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			parsed.err = fmt.Errorf("invalid price %q", value)
+			return parsed
+		}
+		row.Price = &price
+	}
+	if value, ok := field("stock"); ok && value != "" {
+		stock, err := strconv.Atoi(value)
+		if err != nil {
+			parsed.err = fmt.Errorf("invalid stock %q", value)
+			return parsed
+		}
+		row.Stock = &stock
+	}
+	if value, ok := field("categories"); ok {
+		slugs := []string{}
+		for _, slug := range strings.Split(value, "|") {
+			if slug = strings.TrimSpace(slug); slug != "" {
+				slugs = append(slugs, slug)
+			}
+		}
+		row.Categories = &slugs
+	}
+	return parsed
+}
+
+func parseNDJSON(data []byte) ([]parsedRow, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, 1<<20)
+
+	var rows []parsedRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		if len(rows) == maxImportRows {
+			return nil, fmt.Errorf("file has more than %d rows", maxImportRows)
+		}
+
+		parsed := parsedRow{line: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&parsed.row); err != nil {
+			parsed.err = fmt.Errorf("invalid JSON: %v", err)
+		} else if decoder.More() {
+			parsed.err = errors.New("invalid JSON: expected one object per line")
+		}
+		rows = append(rows, parsed)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("invalid NDJSON: %w", err)
+	}
+	return rows, nil
+}
+
+// ImportProductsJob creates and updates the job's seller's products from an
+// uploaded file, or only validates them in a dry run, and returns the report
+// as JSON. Rows are applied independently, so valid rows are saved even when
+// others are rejected.
+func ImportProductsJob(ctx context.Context, job models.Job) (string, error) {
+	var payload importPayload
+	if err := jobs.DecodePayload(job, &payload); err != nil {
+		return "", err
+	}
+	rows, err := parseImport(payload.Format, []byte(payload.Data))
+	if err != nil {
+		return "", err
+	}
+
+	report := ImportReport{DryRun: payload.DryRun, Total: len(rows), Rows: make([]ImportRowResult, 0, len(rows))}
+	seen := map[string]int{}
+	jobs.SetProgress(job.ID, 0, len(rows))
+	for i, parsed := range rows {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		result := importRow(job.UserID, parsed, payload.DryRun, seen)
+		switch result.Action {
+		case importCreate:
+			report.Created++
+		case importUpdate:
+			report.Updated++
+		default:
+			report.Invalid++
+		}
+		report.Rows = append(report.Rows, result)
+
+		if (i+1)%importProgressEvery == 0 {
+			jobs.SetProgress(job.ID, i+1, len(rows))
+		}
+	}
+	jobs.SetProgress(job.ID, len(rows), len(rows))
+
+	if !payload.DryRun && report.Created+report.Updated > 0 {
+		suggestions.Invalidate()
+	}
+
+	data, err := json.Marshal(report)
+	return string(data), err
+}
+
+// importRow validates one row against the seller's catalog and, unless this is
+// a dry run, saves it. seen maps the SKUs of earlier rows to their line.
+func importRow(sellerID int, parsed parsedRow, dryRun bool, seen map[string]int) ImportRowResult {
+	row := parsed.row
+	row.SKU = strings.TrimSpace(row.SKU)
+	result := ImportRowResult{Line: parsed.line, SKU: row.SKU, Action: importInvalid}
+
+	switch line, duplicate := seen[row.SKU]; {
+	case parsed.err != nil:
+		result.Errors = []string{parsed.err.Error()}
+		return result
+	case row.SKU == "":
+		result.Errors = []string{"sku is required"}
+		return result
+	case duplicate:
+		result.Errors = []string{fmt.Sprintf("sku already appears on line %d", line)}
+		return result
+	}
+	seen[row.SKU] = parsed.line
+
+	var variant models.ProductVariant
+	if err := database.DB.Where("sku = ?", row.SKU).Limit(1).Find(&variant).Error; err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+	var product models.Product
+	var err error
+	if variant.ID != 0 {
+		err = database.DB.First(&product, variant.ProductID).Error
+	} else {
+		err = database.DB.Unscoped().Where("sku = ?", row.SKU).Limit(1).Find(&product).Error
+	}
+	if err != nil {
+		result.Errors = []string{err.Error()}
+		return result
+	}
+	if product.DeletedAt.Valid {
+		result.Errors = []string{"sku belongs to a deleted product"}
+		return result
+	}
+	if product.ID != 0 && product.SellerID != sellerID {
+		result.Errors = []string{"sku belongs to another seller"}
+		return result
+	}
+
+	if variant.ID != 0 {
+		result.Action, result.Errors = importVariant(variant, row, dryRun)
+	} else {
+		result.Action, result.Errors = importProduct(sellerID, product, row, dryRun)
+	}
+	return result
+}
+
+func validPrice(price float64) bool {
+	return price > 0 && !math.IsInf(price, 0)
+}
+
+// importVariant applies a row to an existing variant. Only price and stock
+// can be imported; options are managed through the variant endpoints.
+func importVariant(variant models.ProductVariant, row ImportRow, dryRun bool) (string, []string) {
+	var problems []string
+	if (row.Name != nil && strings.TrimSpace(*row.Name) != "") ||
+		(row.Description != nil && *row.Description != "") ||
+		(row.Categories != nil && len(*row.Categories) > 0) {
+		problems = append(problems, "only price and stock can be imported for a variant sku")
+	}
+	if row.Price != nil {
+		if !validPrice(*row.Price) {
+			problems = append(problems, "price must be positive")
+		}
+		variant.Price = *row.Price
+	}
+	if row.Stock != nil {
+		if *row.Stock < 0 {
+			problems = append(problems, "stock cannot be negative")
+		}
+		variant.Stock = *row.Stock
+	}
+	if len(problems) > 0 {
+		return importInvalid, problems
+	}
+	if dryRun {
+		return importUpdate, nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&variant).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, variant.ProductID)
+	})
+	if err != nil {
+		return importInvalid, []string{err.Error()}
+	}
+	return importUpdate, nil
+}
+
+// importProduct creates a product for a new SKU, or updates the seller's
+// product with that SKU
+func importProduct(sellerID int, product models.Product, row ImportRow, dryRun bool) (string, []string) {
+	action := importUpdate
+	if product.ID == 0 {
+		action = importCreate
+		product = models.Product{SKU: &row.SKU, SellerID: sellerID}
+	}
+
+	var problems []string
+	if row.Name != nil {
+		product.Name = strings.TrimSpace(*row.Name)
+	}
+	if product.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if row.Description != nil {
+		product.Description = *row.Description
+	}
+	if row.Price != nil {
+		product.Price = *row.Price
+	}
+	if !validPrice(product.Price) {
+		problems = append(problems, "price must be positive")
+	}
+	if row.Stock != nil {
+		variants, err := hasVariants(database.DB, product.ID)
+		switch {
+		case err != nil:
+			problems = append(problems, err.Error())
+		case variants:
+			problems = append(problems, "stock of this product is tracked per variant; import each variant sku instead")
+		case *row.Stock < 0:
+			problems = append(problems, "stock cannot be negative")
+		}
+		product.Stock = *row.Stock
+	}
+
+	var categories []models.Category
+	if row.Categories != nil && len(*row.Categories) > 0 {
+		if err := database.DB.Where("slug IN ?", *row.Categories).Find(&categories).Error; err != nil {
+			problems = append(problems, err.Error())
+		}
+		found := map[string]bool{}
+		for _, category := range categories {
+			found[category.Slug] = true
+		}
+		for _, slug := range *row.Categories {
+			if !found[slug] {
+				problems = append(problems, fmt.Sprintf("unknown category %q", slug))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return importInvalid, problems
+	}
+	if dryRun {
+		return action, nil
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(&product).Error; err != nil {
+			return err
+		}
+		if row.Categories == nil {
+			return nil
+		}
+		return replaceCategories(tx, &product, categories)
+	})
+	if err != nil {
+		return importInvalid, []string{err.Error()}
+	}
+	return action, nil
+}
+
+// ImportProducts queues an import of the seller's products from a CSV or
+// NDJSON file, sent as the request body or as the "file" field of a multipart
+// form. The format comes from the format parameter, the file extension or the
+// content type. With dry_run=true the rows are only validated.
+func ImportProducts(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	dryRun := false
+	if value := r.URL.Query().Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid dry_run", http.StatusBadRequest)
+			return
+		}
+	}
+	format := r.URL.Query().Get("format")
+
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImportBytes+64<<10)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, fmt.Sprintf("Import file must be at most %d MB", MaxImportBytes>>20), http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "An import file is required in the file field", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		body = file
+		if format == "" {
+			format = filepath.Ext(header.Filename)
+		}
+	} else if format == "" {
+		format = r.Header.Get("Content-Type")
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, MaxImportBytes+1))
+	var tooLarge *http.MaxBytesError
+	if int64(len(data)) > MaxImportBytes || errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Import file must be at most %d MB", MaxImportBytes>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to read import file", http.StatusBadRequest)
+		return
+	}
+
+	format = importFormat(format)
+	if format == "" {
+		http.Error(w, "Set format to csv or ndjson", http.StatusBadRequest)
+		return
+	}
+	// Reject unreadable files now rather than in the background
+	if _, err := parseImport(format, data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := jobs.Enqueue(JobImportProducts, claims.UserID, importPayload{Format: format, DryRun: dryRun, Data: string(data)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/api/seller/products/import/%d", job.ID))
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(ImportJobResponse{Job: job})
+}
+
+// GetProductImports lists the seller's imports, most recent first
+func GetProductImports(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var imports []models.Job
+	if err := database.DB.Where("user_id = ? AND type = ?", claims.UserID, JobImportProducts).
+		Order("created_at DESC").Find(&imports).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(imports)
+}
+
+// GetProductImport returns the progress of one of the seller's imports, and
+// its report once finished
+func GetProductImport(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var job models.Job
+	err := database.DB.Where("id = ? AND user_id = ? AND type = ?", mux.Vars(r)["job_id"], claims.UserID, JobImportProducts).
+		First(&job).Error
+	if err != nil {
+		http.Error(w, "Import not found", http.StatusNotFound)
+		return
+	}
+
+	response := ImportJobResponse{Job: job}
+	if job.Status == jobs.StatusSucceeded && job.Result != "" {
+		var report ImportReport
+		if err := json.Unmarshal([]byte(job.Result), &report); err == nil {
+			response.Report = &report
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// exportRows lists each product followed by its variants. The stock of
+// products with variants is left out as it is derived from the variants.
+// Products without a SKU are skipped and counted, as import could not match them.
+func exportRows(products []models.Product) (rows []ImportRow, skipped int) {
+	rows = make([]ImportRow, 0, len(products))
+	for _, product := range products {
+		if product.SKU == nil || *product.SKU == "" {
+			skipped++
+			continue
+		}
+
+		slugs := make([]string, 0, len(product.Categories))
+		for _, category := range product.Categories {
+			slugs = append(slugs, category.Slug)
+		}
+
+		row := ImportRow{
+			SKU:         *product.SKU,
+			Name:        &product.Name,
+			Description: &product.Description,
+			Price:       &product.Price,
+			Categories:  &slugs,
+		}
+		if len(product.Variants) == 0 {
+			row.Stock = &product.Stock
+		}
+		rows = append(rows, row)
+
+		for _, variant := range product.Variants {
+			rows = append(rows, ImportRow{SKU: variant.SKU, Price: &variant.Price, Stock: &variant.Stock})
+		}
+	}
+	return rows, skipped
+}
+
+// csvRecord formats a row in the order of importColumns
+func (row ImportRow) csvRecord() []string {
+	record := []string{row.SKU, "", "", "", "", ""}
+	if row.Name != nil {
+		record[1] = *row.Name
+	}
+	if row.Description != nil {
+		record[2] = *row.Description
+	}
+	if row.Price != nil {
+		record[3] = strconv.FormatFloat(*row.Price, 'f', -1, 64)
+	}
+	if row.Stock != nil {
+		record[4] = strconv.Itoa(*row.Stock)
+	}
+	if row.Categories != nil {
+		record[5] = strings.Join(*row.Categories, "|")
+	}
+	return record
+}
+
+// ExportProducts downloads the seller's products and variants in the import
+// format, as CSV by default or NDJSON with format=ndjson. Products without a
+// SKU cannot be imported, so they are left out and counted in X-Skipped-Products.
+func ExportProducts(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	format := "csv"
+	if value := r.URL.Query().Get("format"); value != "" {
+		format = importFormat(value)
+	}
+	if format == "" {
+		http.Error(w, "Set format to csv or ndjson", http.StatusBadRequest)
+		return
+	}
+
+	var products []models.Product
+	if err := database.DB.Preload("Categories").Preload("Variants", orderedVariants).
+		Where("seller_id = ?", claims.UserID).Order("id ASC").Find(&products).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rows, skipped := exportRows(products)
+
+	w.Header().Set("X-Skipped-Products", strconv.Itoa(skipped))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	if format == "ndjson" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		encoder := json.NewEncoder(w)
+		for _, row := range rows {
+			encoder.Encode(row)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write(importColumns)
+	for _, row := range rows {
+		writer.Write(row.csvRecord())
+	}
+	writer.Flush()
+}
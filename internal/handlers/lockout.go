@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/lockout"
+	"github.com/MdHisham-04/E-Commerce/internal/mailer"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+const accountUnlockTTL = 24 * time.Hour
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + middleware.ClientIP(r)
+}
+
+// loginAttempt is a login or password check counted against the account and
+// client IP before the credentials are checked
+type loginAttempt struct {
+	account, ip lockout.Attempt
+}
+
+// beginLogin reserves an attempt for the account and client IP, so parallel
+// guesses cannot all pass the throttle before any of them fails. It writes a
+// 429 response with Retry-After and returns false when either must wait before
+// trying again. Should the attempts store fail, logins are refused rather than
+// left unthrottled.
+func beginLogin(w http.ResponseWriter, r *http.Request, email string) (*loginAttempt, bool) {
+	ip, err := IPLimiter.Reserve(ipKey(r))
+	if err != nil {
+		log.Println("ERROR: login attempts store unavailable, refusing login:", err)
+		sendError(w, "Login is temporarily unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if ip.Wait > 0 {
+		sendThrottled(w, ip)
+		return nil, false
+	}
+
+	account, err := AccountLimiter.Reserve(accountKey(email))
+	if err != nil {
+		log.Println("ERROR: login attempts store unavailable, refusing login:", err)
+		releaseAttempt(IPLimiter, ip)
+		sendError(w, "Login is temporarily unavailable", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	if account.Wait > 0 {
+		releaseAttempt(IPLimiter, ip)
+		sendThrottled(w, account)
+		return nil, false
+	}
+
+	return &loginAttempt{account: account, ip: ip}, true
+}
+
+func sendThrottled(w http.ResponseWriter, attempt lockout.Attempt) {
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(attempt.Wait.Seconds()))))
+	if attempt.Locked {
+		sendError(w, "Account temporarily locked after too many failed attempts. Check your email to unlock it", http.StatusTooManyRequests)
+	} else {
+		sendError(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+	}
+}
+
+func releaseAttempt(limiter *lockout.Limiter, attempt lockout.Attempt) {
+	if err := limiter.Release(attempt); err != nil {
+		log.Println("Failed to release login attempt:", err)
+	}
+}
+
+// succeed clears the failures of the account and gives back the IP attempt
+func (a *loginAttempt) succeed() {
+	if err := AccountLimiter.Reset(a.account.Key); err != nil {
+		log.Println("Failed to reset login attempts:", err)
+	}
+	releaseAttempt(IPLimiter, a.ip)
+}
+
+// fail keeps the attempt counted and sends the unlock email when it locked the
+// account. user is nil when the email does not belong to an account.
+func (a *loginAttempt) fail(user *models.User) {
+	if a.account.NewlyLocked && user != nil {
+		if err := sendUnlockEmail(*user); err != nil {
+			log.Printf("Failed to send unlock email to user %d: %v", user.ID, err)
+		}
+	}
+}
+
+// sendUnlockEmail tells a locked-out user and gives them a link to unlock the account
+func sendUnlockEmail(user models.User) error {
+	token, err := auth.NewOneTimeToken(user.ID, auth.PurposeAccountUnlock, accountUnlockTTL)
+	if err != nil {
+		return err
+	}
+
+	return Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your account has been locked",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account was temporarily locked after too many failed login attempts.\nIf this was you, unlock it now with the link below:\n\n%s/login.html?unlock_token=%s\n\nIf it was not you, consider resetting your password.\n",
+			user.Name, AppURL, token),
+	})
+}
+
+// UnlockAccount clears a lockout using the token from the unlock email
+func UnlockAccount(w http.ResponseWriter, r *http.Request) {
+	var req TokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		sendError(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.ConsumeOneTimeToken(req.Token, auth.PurposeAccountUnlock)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := AccountLimiter.Reset(accountKey(user.Email)); err != nil {
+		sendError(w, "Failed to unlock account", http.StatusInternalServerError)
+		return
+	}
+
+	sendMessage(w, "Account unlocked", http.StatusOK)
+}
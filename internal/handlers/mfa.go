@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+type TOTPSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+type MFACodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// checkTOTP verifies a TOTP code for the user and records the time step so the
+// same code cannot be replayed
+func checkTOTP(user models.User, code string) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+
+	step, ok := auth.VerifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return false
+	}
+
+	result := database.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
+// currentUser loads the authenticated user from the database
+func currentUser(r *http.Request) (models.User, error) {
+	claims := middleware.GetUserFromContext(r)
+
+	var user models.User
+	err := database.DB.First(&user, claims.UserID).Error
+	return user, err
+}
+
+// SetupTOTP generates a new TOTP secret for the user. MFA is not enabled until
+// the secret is confirmed with ConfirmTOTP.
+func SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.MFAEnabled {
+		sendError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		sendError(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPSetupResponse{
+		Secret: secret,
+		URI:    auth.TOTPURI(secret, user.Email, MFAIssuer),
+	})
+}
+
+// ConfirmTOTP enables MFA once the user proves their authenticator produces valid
+// codes, and returns a set of recovery codes
+func ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		sendError(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.MFAEnabled {
+		sendError(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	if !checkTOTP(user, req.Code) {
+		sendError(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := auth.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		sendError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DB.Model(&user).Update("mfa_enabled", true).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP turns off MFA after checking the password and a current code.
+// Failures count towards the login lockout, so a stolen access token cannot be
+// used to guess them.
+func DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !user.MFAEnabled {
+		sendError(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	attempt, allowed := beginLogin(w, r, user.Email)
+	if !allowed {
+		return
+	}
+	if ok, _ := auth.CheckPassword(user.Password, req.Password); !ok || !checkTOTP(user, req.Code) {
+		attempt.fail(&user)
+		sendError(w, "Invalid password or code", http.StatusUnauthorized)
+		return
+	}
+	attempt.succeed()
+
+	if err := database.DB.Model(&user).Updates(map[string]interface{}{"mfa_enabled": false, "totp_secret": ""}).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	database.DB.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a
+// current code, counting failures towards the login lockout
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req MFACodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		sendError(w, "Code is required", http.StatusBadRequest)
+		return
+	}
+
+	user, err := currentUser(r)
+	if err != nil {
+		sendError(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if !user.MFAEnabled {
+		sendError(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	attempt, allowed := beginLogin(w, r, user.Email)
+	if !allowed {
+		return
+	}
+	if !checkTOTP(user, req.Code) {
+		attempt.fail(&user)
+		sendError(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	attempt.succeed()
+
+	codes, err := auth.ReplaceRecoveryCodes(user.ID)
+	if err != nil {
+		sendError(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// VerifyMFA completes a two-step login by exchanging the challenge token from
+// Login plus a TOTP or recovery code for an access/refresh token pair
+func VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFAVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.MFAToken == "" || (req.Code == "" && req.RecoveryCode == "") {
+		sendError(w, "MFA token and a code or recovery code are required", http.StatusBadRequest)
+		return
+	}
+
+	challenge, err := auth.ValidateMFAChallenge(req.MFAToken)
+	if err != nil {
+		sendError(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, challenge.UserID).Error; err != nil || !user.MFAEnabled {
+		sendError(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	if user.SuspendedAt != nil {
+		sendError(w, "Account suspended", http.StatusForbidden)
+		return
+	}
+
+	// Second-factor guesses count towards the same lockout as passwords
+	attempt, allowed := beginLogin(w, r, user.Email)
+	if !allowed {
+		return
+	}
+
+	var ok bool
+	if req.Code != "" {
+		ok = checkTOTP(user, req.Code)
+	} else {
+		ok = auth.UseRecoveryCode(user.ID, req.RecoveryCode)
+	}
+	if !ok {
+		attempt.fail(&user)
+		sendError(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	attempt.succeed()
+
+	// A challenge can only be completed once
+	auth.RevokeAccessToken(challenge)
+
+	response, err := issueTokens(r, user, true)
+	if err != nil {
+		sendError(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+var errDeletedAccount = errors.New("this account has been deleted")
+
+var errUnverifiedLocalAccount = errors.New("an account with this email exists but has not been verified; log in with your password and verify your email first")
+
+// oidcStateCookie ties a login to the browser that started it, so an attacker
+// cannot complete their own login in a victim's browser with a callback link
+const oidcStateCookie = "oidc_state"
+
+// oidcStateCookiePath scopes the state cookie to the provider's routes, which
+// include the callback
+func oidcStateCookiePath(r *http.Request) string {
+	return path.Dir(r.URL.Path)
+}
+
+// OIDCStart redirects the browser to the provider's login page
+func OIDCStart(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := OIDCProviders[name]
+	if !ok {
+		sendError(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	state, nonce, verifier, err := auth.NewOIDCLoginState(name)
+	if err != nil {
+		sendError(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	url, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println("OIDC:", err)
+		sendError(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	// Lax rather than Strict, as the provider's redirect back is a cross-site navigation
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     oidcStateCookiePath(r),
+		MaxAge:   int(auth.OIDCStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || strings.HasPrefix(AppURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// OIDCCallback completes an external login. The user is found by their linked
+// identity, linked to an existing account with the same verified email, or
+// created.
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := OIDCProviders[name]
+	if !ok {
+		sendError(w, "Unknown identity provider", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	if e := query.Get("error"); e != "" {
+		sendError(w, "Login failed: "+e, http.StatusUnauthorized)
+		return
+	}
+
+	// The state must come back to the browser that started the login
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(query.Get("state"))) != 1 {
+		sendError(w, auth.ErrInvalidOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: oidcStateCookiePath(r), MaxAge: -1, HttpOnly: true})
+
+	state, err := auth.ConsumeOIDCLoginState(query.Get("state"), name)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	idToken, err := provider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier)
+	if err != nil {
+		log.Println("OIDC:", err)
+		sendError(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+	if idToken.Nonce != state.Nonce {
+		sendError(w, "Login failed", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := userForIdentity(name, idToken)
+	if err == errUnverifiedLocalAccount {
+		sendError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		sendError(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	completeLogin(w, r, user)
+}
+
+// userForIdentity returns the user linked to the provider account, linking or
+// creating one on first login
+func userForIdentity(provider string, idToken *oidc.IDToken) (models.User, error) {
+	var user models.User
+
+	var identity models.UserIdentity
+	err := database.DB.Preload("User").Where("provider = ? AND subject = ?", provider, idToken.Subject).First(&identity).Error
+	if err == nil {
+		// The user is not loaded when their account has been deleted
+		if identity.User.ID == 0 {
+			return user, errDeletedAccount
+		}
+		return identity.User, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	// Linking by email is only safe when the provider vouches for the address
+	email := strings.ToLower(strings.TrimSpace(idToken.Email))
+	if email == "" || !idToken.EmailVerified {
+		return user, errors.New("identity provider did not supply a verified email address")
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Unscoped().Where("LOWER(email) = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			if user.DeletedAt.Valid {
+				return errDeletedAccount
+			}
+			// An unverified account may have been registered by someone else
+			// who still knows its password
+			if user.EmailVerifiedAt == nil {
+				return errUnverifiedLocalAccount
+			}
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			if user, err = createExternalUser(tx, email, idToken.Name); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  idToken.Subject,
+			Email:    email,
+		}).Error
+	})
+	return user, err
+}
+
+// createExternalUser creates a buyer account for a first external login. The
+// password is random; the user can set one through password reset.
+func createExternalUser(tx *gorm.DB, email, name string) (models.User, error) {
+	if name == "" {
+		name = strings.Split(email, "@")[0]
+	}
+
+	password, err := auth.HashPassword(auth.NewRandomPassword())
+	if err != nil {
+		return models.User{}, err
+	}
+
+	now := time.Now()
+	user := models.User{
+		Email:           email,
+		Name:            name,
+		Password:        password,
+		EmailVerifiedAt: &now,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return user, err
+	}
+	return user, auth.AssignRoles(tx, &user, "buyer")
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc/oidctest"
+	"github.com/gorilla/mux"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testDB connects to the database in TEST_DATABASE_URL, skipping the test when
+// it is not set
+func testDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	database.DB = db
+	if err := database.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.SeedRoles(); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.InitKeys(auth.KeyConfig{Algorithm: "HS256", Secret: "test-secret", Environment: "test"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type oidcTest struct {
+	t      *testing.T
+	mock   *oidctest.Provider
+	router *mux.Router
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	mock := oidctest.NewProvider("client")
+	t.Cleanup(mock.Close)
+
+	previous := OIDCProviders
+	OIDCProviders = map[string]*oidc.Provider{
+		"mock": oidc.NewProvider(mock.Config("mock", "http://app.test/api/auth/oidc/mock/callback")),
+	}
+	t.Cleanup(func() { OIDCProviders = previous })
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/auth/oidc/{provider}/start", OIDCStart)
+	router.HandleFunc("/api/auth/oidc/{provider}/callback", OIDCCallback)
+	return &oidcTest{t: t, mock: mock, router: router}
+}
+
+// login runs the flow up to the callback, returning the state cookie, state and code
+func (o *oidcTest) login(identity oidctest.Identity) (*http.Cookie, string, string) {
+	o.t.Helper()
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/start", nil))
+	if rec.Code != http.StatusFound {
+		o.t.Fatalf("start status = %d: %s", rec.Code, rec.Body)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		o.t.Fatal("start did not set the state cookie")
+	}
+
+	state, code, err := o.mock.Authorize(rec.Header().Get("Location"), identity)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return cookie, state, code
+}
+
+func (o *oidcTest) callback(cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {code}}
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/mock/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, r)
+	return rec
+}
+
+func uniqueEmail(name string) string {
+	return fmt.Sprintf("%s+%d@example.com", name, time.Now().UnixNano())
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	o := newOIDCTest(t)
+
+	if rec := o.callback(nil, "state", "code"); rec.Code != http.StatusBadRequest {
+		t.Errorf("without cookie status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// A state started in the attacker's browser does not match the victim's cookie
+	cookie := &http.Cookie{Name: oidcStateCookie, Value: "victim-state"}
+	if rec := o.callback(cookie, "attacker-state", "code"); rec.Code != http.StatusBadRequest {
+		t.Errorf("with another state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCLogin(t *testing.T) {
+	testDB(t)
+	o := newOIDCTest(t)
+	email := uniqueEmail("new")
+
+	cookie, state, code := o.login(oidctest.Identity{Subject: email, Email: email, EmailVerified: true, Name: "New"})
+	if cookie.Value != state || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("state cookie = %+v, want HttpOnly, SameSite=Lax and holding the state", cookie)
+	}
+
+	rec := o.callback(cookie, state, code)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", rec.Code, rec.Body)
+	}
+	var response AuthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Token == "" || response.User.Email != email {
+		t.Errorf("unexpected response %+v", response)
+	}
+
+	// Each state completes at most one login
+	if rec := o.callback(cookie, state, code); rec.Code != http.StatusBadRequest {
+		t.Errorf("reused state status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	testDB(t)
+	o := newOIDCTest(t)
+	email := uniqueEmail("nonce")
+
+	cookie, state, code := o.login(oidctest.Identity{Subject: email, Email: email, EmailVerified: true, Nonce: "replayed"})
+	if rec := o.callback(cookie, state, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestOIDCLinksVerifiedEmail(t *testing.T) {
+	testDB(t)
+	o := newOIDCTest(t)
+
+	now := time.Now()
+	verified := models.User{Email: uniqueEmail("verified"), Name: "Verified", Password: "x", EmailVerifiedAt: &now}
+	unverified := models.User{Email: uniqueEmail("unverified"), Name: "Unverified", Password: "x"}
+	for _, user := range []*models.User{&verified, &unverified} {
+		if err := database.DB.Create(user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		identity oidctest.Identity
+		want     int
+	}{
+		{"verified email links", oidctest.Identity{Subject: verified.Email, Email: verified.Email, EmailVerified: true}, http.StatusOK},
+		{"unverified claim refused", oidctest.Identity{Subject: "unclaimed-" + verified.Email, Email: verified.Email}, http.StatusForbidden},
+		{"unverified local account refused", oidctest.Identity{Subject: unverified.Email, Email: unverified.Email, EmailVerified: true}, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := o.callback(o.login(tt.identity))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+		})
+	}
+
+	var identity models.UserIdentity
+	if err := database.DB.Where("provider = ? AND subject = ?", "mock", verified.Email).First(&identity).Error; err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserID != verified.ID {
+		t.Errorf("identity linked to user %d, want %d", identity.UserID, verified.ID)
+	}
+}
@@ -11,6 +11,15 @@ import (
 	"gorm.io/gorm"
 )
 
+// productName names a cart item's product in error messages, by ID when the
+// product has been purged
+func productName(item models.CartItem) string {
+	if item.Product.Name == "" {
+		return "Product " + strconv.Itoa(item.ProductID)
+	}
+	return item.Product.Name
+}
+
 // CreateOrder creates an order from cart items
 func CreateOrder(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -28,9 +37,9 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
-	// Get cart items
+	// Get cart items. Deleted products are loaded too, so they can be named.
 	var cartItems []models.CartItem
-	if err := tx.Preload("Product").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
+	if err := tx.Preload("Product", withDeleted).Preload("Variant").Where("user_id = ?", userID).Find(&cartItems).Error; err != nil {
 		tx.Rollback()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -42,15 +51,57 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Products that are deleted, unpublished or sold by a deleted seller cannot be ordered
+	productIDs := make([]int, 0, len(cartItems))
+	for _, item := range cartItems {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	var onSale []int
+	if err := tx.Model(&models.Product{}).Scopes(publishedProducts).
+		Where("products.id IN ?", productIDs).Pluck("products.id", &onSale).Error; err != nil {
+		tx.Rollback()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	available := make(map[int]bool, len(onSale))
+	for _, id := range onSale {
+		available[id] = true
+	}
+
 	// Calculate total and check stock
 	var total float64
 	for _, item := range cartItems {
-		if item.Product.Stock < item.Quantity {
+		if !available[item.ProductID] {
 			tx.Rollback()
-			http.Error(w, "Insufficient stock for "+item.Product.Name, http.StatusBadRequest)
+			http.Error(w, productName(item)+" is no longer available", http.StatusBadRequest)
 			return
 		}
-		total += item.Product.Price * float64(item.Quantity)
+
+		// Variants may have been added since the item was put in the cart
+		if item.Variant == nil {
+			variants, err := hasVariants(tx, item.ProductID)
+			if err != nil {
+				tx.Rollback()
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if variants {
+				tx.Rollback()
+				http.Error(w, "Choose a variant of "+productName(item), http.StatusBadRequest)
+				return
+			}
+		}
+
+		price, stock := item.Product.Price, item.Product.Stock
+		if item.Variant != nil {
+			price, stock = item.Variant.Price, item.Variant.Stock
+		}
+		if stock < item.Quantity {
+			tx.Rollback()
+			http.Error(w, "Insufficient stock for "+productName(item), http.StatusBadRequest)
+			return
+		}
+		total += price * float64(item.Quantity)
 	}
 
 	// Create order
@@ -71,10 +122,14 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 		orderItem := models.OrderItem{
 			OrderID:   order.ID,
 			ProductID: item.ProductID,
+			VariantID: item.VariantID,
 			Quantity:  item.Quantity,
 			Price:     item.Product.Price,
 			Status:    "pending",
 		}
+		if item.Variant != nil {
+			orderItem.Price = item.Variant.Price
+		}
 
 		if err := tx.Create(&orderItem).Error; err != nil {
 			tx.Rollback()
@@ -82,12 +137,25 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Update product stock
-		if err := tx.Model(&models.Product{}).Where("id = ?", item.ProductID).
-			Update("stock", gorm.Expr("stock - ?", item.Quantity)).Error; err != nil {
-			tx.Rollback()
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		// Update variant and product stock. A product's stock is the total of its
+		// variants, so both go down. The stock condition stops concurrent orders
+		// from overselling.
+		stock := []*gorm.DB{tx.Model(&models.Product{}).Where("id = ? AND stock >= ?", item.ProductID, item.Quantity)}
+		if item.VariantID != nil {
+			stock = append(stock, tx.Model(&models.ProductVariant{}).Where("id = ? AND stock >= ?", *item.VariantID, item.Quantity))
+		}
+		for _, query := range stock {
+			result := query.Update("stock", gorm.Expr("stock - ?", item.Quantity))
+			if result.Error != nil {
+				tx.Rollback()
+				http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+				return
+			}
+			if result.RowsAffected == 0 {
+				tx.Rollback()
+				http.Error(w, "Insufficient stock for "+productName(item), http.StatusConflict)
+				return
+			}
 		}
 	}
 
@@ -105,7 +173,7 @@ func CreateOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Load order with items
-	database.DB.Preload("OrderItems.Product").First(&order, order.ID)
+	database.DB.Preload("OrderItems.Product", withDeleted).Preload("OrderItems.Variant").First(&order, order.ID)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -122,7 +190,7 @@ func GetOrders(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var orders []models.Order
-	result := database.DB.Preload("OrderItems.Product").Where("user_id = ?", userID).Find(&orders)
+	result := database.DB.Preload("OrderItems.Product", withDeleted).Preload("OrderItems.Variant").Where("user_id = ?", userID).Find(&orders)
 
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
@@ -143,7 +211,7 @@ func GetOrder(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var order models.Order
-	result := database.DB.Preload("OrderItems.Product").First(&order, orderID)
+	result := database.DB.Preload("OrderItems.Product", withDeleted).Preload("OrderItems.Variant").First(&order, orderID)
 
 	if result.Error != nil {
 		http.Error(w, "Order not found", http.StatusNotFound)
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/jobs"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+)
+
+// Background job types for data subject requests
+const (
+	JobExportUserData = "user_data_export"
+	JobEraseUserData  = "user_data_erasure"
+)
+
+type JobResponse struct {
+	models.Job
+	DownloadURL string `json:"download_url,omitempty"`
+}
+
+// newJobResponse adds the download link to finished exports that are still available
+func newJobResponse(job models.Job, downloadPrefix string) JobResponse {
+	response := JobResponse{Job: job}
+	if job.Type == JobExportUserData && job.Status == jobs.StatusSucceeded && job.Result != "" {
+		response.DownloadURL = fmt.Sprintf("%s/%d/download", downloadPrefix, job.ID)
+	}
+	return response
+}
+
+// ExportUserDataJob assembles everything stored about the job's user into a
+// ZIP archive of JSON files and returns the archive's file name
+func ExportUserDataJob(ctx context.Context, job models.Job) (string, error) {
+	var user models.User
+	if err := database.DB.Preload("Roles").First(&user, job.UserID).Error; err != nil {
+		return "", err
+	}
+
+	var identities []models.UserIdentity
+	var sessions []models.Session
+	var apiKeys []models.APIKey
+	var cart []models.CartItem
+	var orders []models.Order
+	var products []models.Product
+	var reviews []models.Review
+
+	queries := []error{
+		database.DB.Where("user_id = ?", user.ID).Find(&identities).Error,
+		database.DB.Where("user_id = ?", user.ID).Find(&sessions).Error,
+		database.DB.Where("user_id = ?", user.ID).Find(&apiKeys).Error,
+		database.DB.Preload("Product").Preload("Variant").Where("user_id = ?", user.ID).Find(&cart).Error,
+		database.DB.Preload("OrderItems.Product", withDeleted).Preload("OrderItems.Variant").Where("user_id = ?", user.ID).Order("created_at ASC").Find(&orders).Error,
+		database.DB.Where("seller_id = ?", user.ID).Find(&products).Error,
+		database.DB.Where("user_id = ?", user.ID).Find(&reviews).Error,
+	}
+	for _, err := range queries {
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if err := os.MkdirAll(ExportDir, 0o700); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("user-%d-export-%d.zip", user.ID, job.ID)
+	file, err := os.OpenFile(filepath.Join(ExportDir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", user},
+		{"identities.json", identities},
+		{"sessions.json", sessions},
+		{"api_keys.json", apiKeys},
+		{"cart.json", cart},
+		{"orders.json", orders},
+		{"products.json", products},
+		{"reviews.json", reviews},
+	}
+	for _, section := range sections {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		w, err := archive.Create(section.name)
+		if err != nil {
+			return "", err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(section.data); err != nil {
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	return name, file.Close()
+}
+
+// EraseUserDataJob pseudonymizes the job's user, keeping their orders
+func EraseUserDataJob(ctx context.Context, job models.Job) (string, error) {
+	return "", anonymizeUser(job.UserID)
+}
+
+// RequestDataExport queues an export of the current user's data
+func RequestDataExport(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	job, err := jobs.Enqueue(JobExportUserData, claims.UserID, nil)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newJobResponse(job, "/api/me/data-exports"))
+}
+
+// GetDataExports lists the current user's data exports
+func GetDataExports(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var exports []models.Job
+	if err := database.DB.Where("user_id = ? AND type = ?", claims.UserID, JobExportUserData).
+		Order("created_at DESC").Find(&exports).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]JobResponse, 0, len(exports))
+	for _, job := range exports {
+		response = append(response, newJobResponse(job, "/api/me/data-exports"))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetDataExport returns the status of one of the current user's data exports
+func GetDataExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := ownExport(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job, "/api/me/data-exports"))
+}
+
+// DownloadDataExport serves a finished export of the current user's data
+func DownloadDataExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := ownExport(w, r)
+	if !ok {
+		return
+	}
+	serveExport(w, r, job)
+}
+
+func ownExport(w http.ResponseWriter, r *http.Request) (models.Job, bool) {
+	claims := middleware.GetUserFromContext(r)
+
+	var job models.Job
+	err := database.DB.Where("id = ? AND user_id = ? AND type = ?", mux.Vars(r)["id"], claims.UserID, JobExportUserData).
+		First(&job).Error
+	if err != nil {
+		sendError(w, "Export not found", http.StatusNotFound)
+		return job, false
+	}
+	return job, true
+}
+
+func serveExport(w http.ResponseWriter, r *http.Request, job models.Job) {
+	if job.Status != jobs.StatusSucceeded {
+		sendError(w, "Export is not ready", http.StatusConflict)
+		return
+	}
+	if job.Result == "" {
+		sendError(w, "Export has expired", http.StatusGone)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, job.Result))
+	http.ServeFile(w, r, filepath.Join(ExportDir, filepath.Base(job.Result)))
+}
+
+// AdminExportUserData queues an export of a user's data to answer a data
+// subject access request
+func AdminExportUserData(w http.ResponseWriter, r *http.Request) {
+	adminEnqueue(w, r, JobExportUserData)
+}
+
+// AdminEraseUserData queues erasure of a user's personal data. Orders are kept
+// for financial records, linked to the pseudonymized user.
+func AdminEraseUserData(w http.ResponseWriter, r *http.Request) {
+	adminEnqueue(w, r, JobEraseUserData)
+}
+
+func adminEnqueue(w http.ResponseWriter, r *http.Request, jobType string) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if jobType == JobEraseUserData {
+		roles, _, err := auth.LoadRoles(user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, role := range roles {
+			if role == "admin" {
+				http.Error(w, "Administrators must be demoted before their data is erased", http.StatusConflict)
+				return
+			}
+		}
+	}
+
+	job, err := jobs.Enqueue(jobType, user.ID, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newJobResponse(job, "/api/admin/jobs"))
+}
+
+// AdminGetJob returns the status of a background job
+func AdminGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := adminJob(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newJobResponse(job, "/api/admin/jobs"))
+}
+
+// AdminDownloadJob serves the archive produced by a data export job
+func AdminDownloadJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := adminJob(w, r)
+	if !ok {
+		return
+	}
+	if job.Type != JobExportUserData {
+		http.Error(w, "Job has no download", http.StatusNotFound)
+		return
+	}
+	serveExport(w, r, job)
+}
+
+func adminJob(w http.ResponseWriter, r *http.Request) (models.Job, bool) {
+	var job models.Job
+	if err := database.DB.First(&job, mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return job, false
+	}
+	return job, true
+}
+
+// PurgeExpiredExports deletes export archives older than ExportTTL
+func PurgeExpiredExports() error {
+	var expired []models.Job
+	if err := database.DB.Where("type = ? AND status = ? AND result <> '' AND finished_at < ?",
+		JobExportUserData, jobs.StatusSucceeded, time.Now().Add(-ExportTTL)).Find(&expired).Error; err != nil {
+		return err
+	}
+
+	for _, job := range expired {
+		if err := removeExport(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeUserExports deletes every export archive of a user, including any
+// written by a job that did not record it
+func removeUserExports(userID int) error {
+	var exports []models.Job
+	if err := database.DB.Where("type = ? AND user_id = ? AND result <> ''", JobExportUserData, userID).Find(&exports).Error; err != nil {
+		return err
+	}
+	for _, job := range exports {
+		if err := removeExport(job); err != nil {
+			return err
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(ExportDir, fmt.Sprintf("user-%d-export-*.zip", userID)))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeExport deletes a job's archive and forgets it, so it can no longer be downloaded
+func removeExport(job models.Job) error {
+	err := os.Remove(filepath.Join(ExportDir, filepath.Base(job.Result)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return database.DB.Model(&job).Update("result", "").Error
+}
@@ -2,24 +2,166 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/MdHisham-04/E-Commerce/internal/database"
 	"github.com/MdHisham-04/E-Commerce/internal/models"
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-// GetProducts returns all products available in the store
+// Product statuses. Only published products are listed, shown and sold; drafts
+// are prepared by their seller and archived products are kept for order history.
+const (
+	ProductDraft     = "draft"
+	ProductPublished = "published"
+	ProductArchived  = "archived"
+)
+
+// productTransitions lists the statuses a product can move to from each status
+var productTransitions = map[string][]string{
+	ProductDraft:     {ProductPublished, ProductArchived},
+	ProductPublished: {ProductDraft, ProductArchived},
+	ProductArchived:  {ProductDraft},
+}
+
+// sellerNotDeleted matches products whose seller's account has not been deleted
+const sellerNotDeleted = "EXISTS (SELECT 1 FROM users WHERE users.id = products.seller_id AND users.deleted_at IS NULL)"
+
+// publishedProducts restricts a product query to products that are on sale
+func publishedProducts(db *gorm.DB) *gorm.DB {
+	return db.Where("products.status = ?", ProductPublished).Where(sellerNotDeleted)
+}
+
+// productSorts maps the sort query parameter to an ORDER BY clause. Ties are
+// broken by ID so pages are stable.
+var productSorts = map[string]string{
+	"newest":     "products.created_at DESC, products.id DESC",
+	"oldest":     "products.created_at ASC, products.id ASC",
+	"price_asc":  "products.price ASC, products.id ASC",
+	"price_desc": "products.price DESC, products.id DESC",
+	"popularity": "COALESCE(sales.sold, 0) DESC, products.id DESC",
+	"rating":     "products.rating_average DESC, products.rating_count DESC, products.id DESC",
+}
+
+// productDetails preloads the categories, options, variants and images shown
+// with a product
+func productDetails(db *gorm.DB) *gorm.DB {
+	return db.Preload("Categories").
+		Preload("Options", orderedOptions).
+		Preload("Variants", orderedVariants).
+		Preload("Images", orderedImages)
+}
+
+// filterProducts restricts a product query to published products and applies
+// the min_price, max_price, in_stock, seller_id and category query parameters
+func filterProducts(query *gorm.DB, r *http.Request) (*gorm.DB, error) {
+	params := r.URL.Query()
+	query = query.Scopes(publishedProducts)
+
+	for param, op := range map[string]string{"min_price": ">=", "max_price": "<="} {
+		if value := params.Get(param); value != "" {
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil || price < 0 {
+				return nil, fmt.Errorf("invalid %s", param)
+			}
+			query = query.Where("products.price "+op+" ?", price)
+		}
+	}
+
+	if value := params.Get("in_stock"); value != "" {
+		inStock, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("invalid in_stock")
+		}
+		if inStock {
+			query = query.Where("products.stock > 0")
+		}
+	}
+
+	if value := params.Get("min_rating"); value != "" {
+		rating, err := strconv.ParseFloat(value, 64)
+		if err != nil || rating < 0 || rating > 5 {
+			return nil, errors.New("invalid min_rating")
+		}
+		query = query.Where("products.rating_average >= ? AND products.rating_count > 0", rating)
+	}
+
+	if value := params.Get("seller_id"); value != "" {
+		sellerID, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, errors.New("invalid seller_id")
+		}
+		query = query.Where("products.seller_id = ?", sellerID)
+	}
+
+	if category := params.Get("category"); category != "" {
+		query = categoryFilter(query, category)
+	}
+
+	return query, nil
+}
+
+// likeEscaper escapes the LIKE wildcards, and the escape character itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// containsPattern returns a LIKE pattern matching text containing s literally,
+// for use with ESCAPE '\'
+func containsPattern(s string) string {
+	return "%" + likeEscaper.Replace(s) + "%"
+}
+
+// GetProducts returns products matching the optional q (name or description),
+// min_price, max_price, in_stock, min_rating, seller_id and category (ID or
+// slug, including subcategories) filters, ordered by sort (newest, oldest,
+// price_asc, price_desc, popularity or rating) and paginated
 func GetProducts(w http.ResponseWriter, r *http.Request) {
+	query, err := filterProducts(database.DB.Model(&models.Product{}), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if q := strings.TrimSpace(r.URL.Query().Get("q")); q != "" {
+		like := containsPattern(q)
+		query = query.Where(`products.name ILIKE ? ESCAPE '\' OR products.description ILIKE ? ESCAPE '\'`, like, like)
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "newest"
+	}
+	order, ok := productSorts[sort]
+	if !ok {
+		http.Error(w, "Invalid sort, expected newest, oldest, price_asc, price_desc, popularity or rating", http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if sort == "popularity" {
+		query = query.Joins("LEFT JOIN (SELECT product_id, SUM(quantity) AS sold FROM order_items GROUP BY product_id) sales ON sales.product_id = products.id")
+	}
+
+	page, perPage := parsePagination(r)
 	var products []models.Product
-	result := database.DB.Preload("Seller").Find(&products)
+	result := query.Preload("Seller").Scopes(productDetails).Order(order).Offset((page - 1) * perPage).Limit(perPage).Find(&products)
 
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setImageURLs(products)
+	setPaginationHeaders(w, r, page, perPage, total)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
@@ -34,12 +176,13 @@ func GetProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var product models.Product
-	result := database.DB.Preload("Seller").First(&product, id)
+	result := database.DB.Preload("Seller").Scopes(productDetails, publishedProducts).First(&product, id)
 
 	if result.Error != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
+	setImageURLs([]models.Product{product})
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(product)
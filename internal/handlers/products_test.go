@@ -0,0 +1,22 @@
+package handlers
+
+import "testing"
+
+func TestContainsPattern(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"shoe", `%shoe%`},
+		{"%", `%\%%`},
+		{"_", `%\_%`},
+		{"50% off_sale", `%50\% off\_sale%`},
+		{`back\slash`, `%back\\slash%`},
+	}
+
+	for _, tt := range tests {
+		if got := containsPattern(tt.in); got != tt.want {
+			t.Errorf("containsPattern(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
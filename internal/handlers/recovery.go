@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/mailer"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+type EmailRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type TokenRequest struct {
+	Token string `json:"token"`
+}
+
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// sendMessage writes a JSON message response with the given status code
+func sendMessage(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(MessageResponse{Message: message})
+}
+
+// sendVerificationEmail emails the user a link to verify their address
+func sendVerificationEmail(user models.User) error {
+	token, err := auth.NewOneTimeToken(user.ID, auth.PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s/api/auth/verify-email?token=%s\n\nThe link expires in %s.\n",
+			user.Name, AppURL, token, emailVerificationTTL),
+	})
+}
+
+// ForgotPassword emails a password reset link if the address belongs to an account
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		sendError(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	// Respond identically whether or not the account exists to avoid leaking registered emails
+	const response = "If the email is registered, a password reset link has been sent"
+
+	var user models.User
+	if err := database.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
+		sendMessage(w, response, http.StatusAccepted)
+		return
+	}
+
+	token, err := auth.NewOneTimeToken(user.ID, auth.PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		sendError(w, "Failed to create reset token", http.StatusInternalServerError)
+		return
+	}
+
+	err = Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password:\n\n%s/login.html?reset_token=%s\n\nThe link expires in %s. If you did not request a reset you can ignore this email.\n",
+			user.Name, AppURL, token, passwordResetTTL),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+
+	sendMessage(w, response, http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Token == "" || req.Password == "" {
+		sendError(w, "Token and password are required", http.StatusBadRequest)
+		return
+	}
+
+	// Checked before the token is consumed so a rejected password can be retried
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.ConsumeOneTimeToken(req.Token, auth.PurposePasswordReset)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		sendError(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := database.DB.Model(&models.User{}).Where("id = ?", userID).Update("password", hashedPassword).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Proving control of the mailbox also lifts any lockout
+	var user models.User
+	if err := database.DB.First(&user, userID).Error; err == nil {
+		AccountLimiter.Reset(accountKey(user.Email))
+	}
+
+	// Sign the user out everywhere now that the old password is no longer valid
+	if err := auth.RevokeAllRefreshTokens(userID); err != nil {
+		log.Printf("Failed to revoke refresh tokens for user %d: %v", userID, err)
+	}
+
+	sendMessage(w, "Password has been reset", http.StatusOK)
+}
+
+// VerifyEmail marks the user's email as verified. The token may be sent as a
+// query parameter (from the emailed link) or in a JSON body.
+func VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var req TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		token = req.Token
+	}
+
+	if token == "" {
+		sendError(w, "Token is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := auth.ConsumeOneTimeToken(token, auth.PurposeEmailVerification)
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := database.DB.Model(&models.User{}).Where("id = ?", userID).Update("email_verified_at", time.Now()).Error; err != nil {
+		sendError(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sendMessage(w, "Email address verified", http.StatusOK)
+}
+
+// ResendVerification sends a fresh verification link to an unverified account
+func ResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Email == "" {
+		sendError(w, "Email is required", http.StatusBadRequest)
+		return
+	}
+
+	const response = "If the email is registered and unverified, a verification link has been sent"
+
+	var user models.User
+	if err := database.DB.Where("email = ? AND email_verified_at IS NULL", req.Email).First(&user).Error; err != nil {
+		sendMessage(w, response, http.StatusAccepted)
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", user.ID, err)
+	}
+
+	sendMessage(w, response, http.StatusAccepted)
+}
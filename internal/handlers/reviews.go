@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/MdHisham-04/E-Commerce/internal/audit"
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// Review statuses. Only published reviews are shown and count towards ratings.
+const (
+	ReviewPublished = "published"
+	ReviewPending   = "pending" // held by ReviewModerator or by reports
+	ReviewHidden    = "hidden"  // removed by a moderator
+)
+
+const (
+	maxReviewTitle  = 120
+	maxReviewBody   = 5000
+	maxReviewReply  = 2000
+	maxReportReason = 500
+)
+
+// reviewSorts maps the sort query parameter to an ORDER BY clause
+var reviewSorts = map[string]string{
+	"newest":      "reviews.created_at DESC, reviews.id DESC",
+	"oldest":      "reviews.created_at ASC, reviews.id ASC",
+	"rating_desc": "reviews.rating DESC, reviews.created_at DESC, reviews.id DESC",
+	"rating_asc":  "reviews.rating ASC, reviews.created_at DESC, reviews.id DESC",
+}
+
+type ReviewRequest struct {
+	Rating *int    `json:"rating"`
+	Title  *string `json:"title"`
+	Body   *string `json:"body"`
+}
+
+type ReviewReplyRequest struct {
+	Reply string `json:"reply"`
+}
+
+type ReviewReportRequest struct {
+	Reason string `json:"reason"`
+}
+
+type ReviewStatusRequest struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type ReviewSummary struct {
+	ProductID    int            `json:"product_id"`
+	Average      float64        `json:"average"`
+	Count        int            `json:"count"`
+	Distribution map[string]int `json:"distribution"`
+}
+
+// syncProductRating recomputes the rating summary stored on a product from
+// its published reviews
+func syncProductRating(tx *gorm.DB, productID int) error {
+	return tx.Exec(`UPDATE products SET
+		rating_count = (SELECT COUNT(*) FROM reviews WHERE product_id = products.id AND status = ?),
+		rating_average = (SELECT COALESCE(ROUND(AVG(rating), 2), 0) FROM reviews WHERE product_id = products.id AND status = ?)
+		WHERE id = ?`, ReviewPublished, ReviewPublished, productID).Error
+}
+
+// withReviewAuthor preloads the name of each review's author, deleted or not
+func withReviewAuthor(db *gorm.DB) *gorm.DB {
+	return db.Preload("User", func(db *gorm.DB) *gorm.DB { return db.Unscoped().Select("id", "name") })
+}
+
+func setReviewAuthors(reviews []models.Review) {
+	for i := range reviews {
+		if reviews[i].User != nil {
+			reviews[i].AuthorName = reviews[i].User.Name
+		}
+	}
+}
+
+// filterReviews applies the rating query parameter, one or more comma-separated
+// values, and returns the ORDER BY clause for the sort parameter
+func filterReviews(query *gorm.DB, r *http.Request) (*gorm.DB, string, error) {
+	if value := r.URL.Query().Get("rating"); value != "" {
+		var ratings []int
+		for _, part := range strings.Split(value, ",") {
+			rating, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || rating < 1 || rating > 5 {
+				return nil, "", errors.New("invalid rating, expected values from 1 to 5")
+			}
+			ratings = append(ratings, rating)
+		}
+		query = query.Where("reviews.rating IN ?", ratings)
+	}
+
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = "newest"
+	}
+	order, ok := reviewSorts[sort]
+	if !ok {
+		return nil, "", errors.New("invalid sort, expected newest, oldest, rating_desc or rating_asc")
+	}
+	return query, order, nil
+}
+
+// writeReviews responds with one page of the reviews matched by query
+func writeReviews(w http.ResponseWriter, r *http.Request, query *gorm.DB) {
+	query, order, err := filterReviews(query.Model(&models.Review{}), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	var reviews []models.Review
+	if err := query.Scopes(withReviewAuthor).Order(order).Offset((page - 1) * perPage).Limit(perPage).Find(&reviews).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setReviewAuthors(reviews)
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// reviewedProduct loads the product named in the URL
+func reviewedProduct(w http.ResponseWriter, r *http.Request) (models.Product, bool) {
+	var product models.Product
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return product, false
+	}
+	if err := database.DB.Scopes(publishedProducts).First(&product, productID).Error; err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return product, false
+	}
+	return product, true
+}
+
+// GetProductReviews returns the published reviews of a product, filtered by
+// rating and ordered by sort (newest, oldest, rating_desc or rating_asc)
+func GetProductReviews(w http.ResponseWriter, r *http.Request) {
+	product, ok := reviewedProduct(w, r)
+	if !ok {
+		return
+	}
+
+	writeReviews(w, r, database.DB.Where("reviews.product_id = ? AND reviews.status = ?", product.ID, ReviewPublished))
+}
+
+// GetReviewSummary returns a product's average rating and the number of
+// published reviews for each rating
+func GetReviewSummary(w http.ResponseWriter, r *http.Request) {
+	product, ok := reviewedProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var counts []struct {
+		Rating int
+		Count  int
+	}
+	if err := database.DB.Model(&models.Review{}).Select("rating, COUNT(*) AS count").
+		Where("product_id = ? AND status = ?", product.ID, ReviewPublished).
+		Group("rating").Scan(&counts).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	summary := ReviewSummary{
+		ProductID:    product.ID,
+		Average:      product.RatingAverage,
+		Count:        product.RatingCount,
+		Distribution: map[string]int{"1": 0, "2": 0, "3": 0, "4": 0, "5": 0},
+	}
+	for _, c := range counts {
+		summary.Distribution[strconv.Itoa(c.Rating)] = c.Count
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// applyReview copies the fields present in req onto review and validates the result
+func applyReview(review *models.Review, req ReviewRequest) error {
+	if req.Rating != nil {
+		review.Rating = *req.Rating
+	}
+	if req.Title != nil {
+		review.Title = strings.TrimSpace(*req.Title)
+	}
+	if req.Body != nil {
+		review.Body = strings.TrimSpace(*req.Body)
+	}
+
+	if review.Rating < 1 || review.Rating > 5 {
+		return errors.New("rating must be from 1 to 5")
+	}
+	if utf8.RuneCountInString(review.Title) > maxReviewTitle {
+		return fmt.Errorf("title must be at most %d characters", maxReviewTitle)
+	}
+	if utf8.RuneCountInString(review.Body) > maxReviewBody {
+		return fmt.Errorf("body must be at most %d characters", maxReviewBody)
+	}
+	return nil
+}
+
+// saveReview stores a review and updates its product's rating summary
+func saveReview(w http.ResponseWriter, review *models.Review) bool {
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("User", "Reports").Save(review).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// CreateReview adds the current user's review of a product. Only buyers with
+// a completed order item for the product may review it, once.
+func CreateReview(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	product, ok := reviewedProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var purchases int64
+	database.DB.Model(&models.OrderItem{}).
+		Joins("JOIN orders ON orders.id = order_items.order_id").
+		Where("orders.user_id = ? AND order_items.product_id = ? AND order_items.status = ?", claims.UserID, product.ID, "completed").
+		Count(&purchases)
+	if purchases == 0 {
+		http.Error(w, "Only buyers who have received this product can review it", http.StatusForbidden)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&models.Review{}).Where("product_id = ? AND user_id = ?", product.ID, claims.UserID).Count(&existing)
+	if existing > 0 {
+		http.Error(w, "You have already reviewed this product", http.StatusConflict)
+		return
+	}
+
+	review := models.Review{ProductID: product.ID, UserID: claims.UserID, VerifiedPurchase: true}
+	if err := applyReview(&review, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	review.Status = ReviewModerator(review)
+
+	if !saveReview(w, &review) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(review)
+}
+
+// ownReview loads the current user's review named in the URL
+func ownReview(w http.ResponseWriter, r *http.Request) (models.Review, bool) {
+	claims := middleware.GetUserFromContext(r)
+
+	var review models.Review
+	err := database.DB.Where("id = ? AND product_id = ? AND user_id = ?", mux.Vars(r)["review_id"], mux.Vars(r)["id"], claims.UserID).
+		First(&review).Error
+	if err != nil {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return review, false
+	}
+	return review, true
+}
+
+// UpdateReview changes the fields present in the request. A published review
+// goes through ReviewModerator again; one held for moderation stays held.
+func UpdateReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := ownReview(w, r)
+	if !ok {
+		return
+	}
+	if review.Status == ReviewHidden {
+		http.Error(w, "This review was removed by a moderator", http.StatusForbidden)
+		return
+	}
+
+	var req ReviewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := applyReview(&review, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Editing must not release a review held after reports
+	if review.Status == ReviewPublished {
+		review.Status = ReviewModerator(review)
+	}
+
+	if !saveReview(w, &review) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// DeleteReview removes the current user's review
+func DeleteReview(w http.ResponseWriter, r *http.Request) {
+	review, ok := ownReview(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&review).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReportReview flags a published review as inappropriate. Once it has
+// ReviewReportThreshold reports it is held for moderation.
+func ReportReview(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var review models.Review
+	err := database.DB.Where("id = ? AND product_id = ? AND status = ?", mux.Vars(r)["review_id"], mux.Vars(r)["id"], ReviewPublished).
+		First(&review).Error
+	if err != nil {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+	if review.UserID == claims.UserID {
+		http.Error(w, "You cannot report your own review", http.StatusBadRequest)
+		return
+	}
+
+	var req ReviewReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if utf8.RuneCountInString(req.Reason) > maxReportReason {
+		http.Error(w, fmt.Sprintf("Reason must be at most %d characters", maxReportReason), http.StatusBadRequest)
+		return
+	}
+
+	var existing int64
+	database.DB.Model(&models.ReviewReport{}).Where("review_id = ? AND user_id = ?", review.ID, claims.UserID).Count(&existing)
+	if existing > 0 {
+		http.Error(w, "You have already reported this review", http.StatusConflict)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		report := models.ReviewReport{ReviewID: review.ID, UserID: claims.UserID, Reason: req.Reason}
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+
+		var reports int64
+		if err := tx.Model(&models.ReviewReport{}).Where("review_id = ?", review.ID).Count(&reports).Error; err != nil {
+			return err
+		}
+		if reports < int64(ReviewReportThreshold) {
+			return nil
+		}
+		if err := tx.Model(&review).Update("status", ReviewPending).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetSellerReviews returns the published reviews of the seller's products,
+// with the same filters as GetProductReviews
+func GetSellerReviews(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	writeReviews(w, r, database.DB.
+		Where("reviews.status = ? AND reviews.product_id IN (?)", ReviewPublished,
+			database.DB.Model(&models.Product{}).Select("id").Where("seller_id = ?", claims.UserID)))
+}
+
+// ReplyToReview sets the seller's public reply to a review of one of their
+// products. An empty reply removes it.
+func ReplyToReview(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var review models.Review
+	err := database.DB.
+		Joins("JOIN products ON products.id = reviews.product_id").
+		Where("reviews.id = ? AND products.seller_id = ?", mux.Vars(r)["id"], claims.UserID).
+		First(&review).Error
+	if err != nil {
+		http.Error(w, "Review not found or access denied", http.StatusNotFound)
+		return
+	}
+
+	var req ReviewReplyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Reply = strings.TrimSpace(req.Reply)
+	if utf8.RuneCountInString(req.Reply) > maxReviewReply {
+		http.Error(w, fmt.Sprintf("Reply must be at most %d characters", maxReviewReply), http.StatusBadRequest)
+		return
+	}
+
+	var repliedAt *time.Time
+	if req.Reply != "" {
+		now := time.Now()
+		repliedAt = &now
+	}
+	if err := database.DB.Model(&review).Updates(map[string]interface{}{
+		"seller_reply":      req.Reply,
+		"seller_replied_at": repliedAt,
+	}).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	review.SellerReply, review.SellerRepliedAt = req.Reply, repliedAt
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
+
+// AdminGetReviews lists reviews with a given status, pending by default, with
+// their reports, oldest first so the moderation queue is worked in order
+func AdminGetReviews(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = ReviewPending
+	}
+	if status != ReviewPublished && status != ReviewPending && status != ReviewHidden {
+		http.Error(w, "Invalid status, expected published, pending or hidden", http.StatusBadRequest)
+		return
+	}
+
+	query := database.DB.Model(&models.Review{}).Where("status = ?", status)
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	var reviews []models.Review
+	if err := query.Scopes(withReviewAuthor).Preload("Reports").Order("updated_at ASC, id ASC").
+		Offset((page - 1) * perPage).Limit(perPage).Find(&reviews).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	setReviewAuthors(reviews)
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reviews)
+}
+
+// ModerateReview publishes or hides a review. Publishing dismisses its reports.
+func ModerateReview(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+
+	var review models.Review
+	if err := database.DB.First(&review, mux.Vars(r)["id"]).Error; err != nil {
+		http.Error(w, "Review not found", http.StatusNotFound)
+		return
+	}
+
+	var req ReviewStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Status != ReviewPublished && req.Status != ReviewHidden {
+		http.Error(w, "Invalid status, expected published or hidden", http.StatusBadRequest)
+		return
+	}
+
+	err := audit.Record(claims.UserID, review.UserID, audit.ActionReviewModerate, middleware.ClientIP(r), map[string]interface{}{
+		"review_id":  review.ID,
+		"product_id": review.ProductID,
+		"from":       review.Status,
+		"to":         req.Status,
+		"reason":     strings.TrimSpace(req.Reason),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if req.Status == ReviewPublished {
+			if err := tx.Where("review_id = ?", review.ID).Delete(&models.ReviewReport{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&review).Update("status", req.Status).Error; err != nil {
+			return err
+		}
+		return syncProductRating(tx, review.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	review.Status = req.Status
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(review)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"html"
+	"net/http"
+	"strings"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+// searchMatch selects products matching the full-text query or, to tolerate
+// typos, whose name is similar to the search terms. Both forms use GIN indexes.
+const searchMatch = `products.search_vector @@ websearch_to_tsquery('english', @q)
+	OR products.name % @q OR @q <% products.name`
+
+// searchScore ranks full-text matches above fuzzy ones
+const searchScore = `ts_rank(products.search_vector, websearch_to_tsquery('english', @q)) * 2
+	+ similarity(products.name, @q)`
+
+// Postgres marks matches with placeholders that become <mark> tags once the
+// product text has been HTML-escaped
+const headlineOptions = `StartSel="{{mark}}", StopSel="{{/mark}}", MaxFragments=2, MaxWords=20, MinWords=5`
+
+var highlightTags = strings.NewReplacer("{{mark}}", "<mark>", "{{/mark}}", "</mark>")
+
+// highlight escapes a ts_headline result and turns its placeholders into tags
+func highlight(headline string) string {
+	return highlightTags.Replace(html.EscapeString(headline))
+}
+
+type SearchHighlights struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+type ProductSearchResult struct {
+	models.Product
+	Score      float64          `json:"score"`
+	Highlights SearchHighlights `json:"highlights"`
+}
+
+type searchHit struct {
+	ID                   int
+	Score                float64
+	NameHighlight        string
+	DescriptionHighlight string
+}
+
+// SearchProducts returns products matching q ranked by relevance, with matched
+// terms wrapped in <mark> tags. The product listing filters also apply.
+func SearchProducts(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "Query parameter q is required", http.StatusBadRequest)
+		return
+	}
+	args := map[string]interface{}{"q": q, "options": headlineOptions}
+
+	query, err := filterProducts(database.DB.Model(&models.Product{}).Where(searchMatch, args), r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
+	var hits []searchHit
+	err = query.Select(`products.id, (`+searchScore+`) AS score,
+		ts_headline('english', products.name, websearch_to_tsquery('english', @q), @options) AS name_highlight,
+		ts_headline('english', coalesce(products.description, ''), websearch_to_tsquery('english', @q), @options) AS description_highlight`, args).
+		Order("score DESC, products.id DESC").
+		Offset((page - 1) * perPage).Limit(perPage).
+		Scan(&hits).Error
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ids := make([]int, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+
+	var products []models.Product
+	if len(ids) > 0 {
+		if err := database.DB.Preload("Seller").Scopes(productDetails).Where("id IN ?", ids).Find(&products).Error; err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	setImageURLs(products)
+	byID := make(map[int]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	results := make([]ProductSearchResult, 0, len(hits))
+	for _, hit := range hits {
+		product, ok := byID[hit.ID]
+		if !ok {
+			continue
+		}
+		results = append(results, ProductSearchResult{
+			Product:    product,
+			Score:      hit.Score,
+			Highlights: SearchHighlights{Name: highlight(hit.NameHighlight), Description: highlight(hit.DescriptionHighlight)},
+		})
+	}
+
+	setPaginationHeaders(w, r, page, perPage, total)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
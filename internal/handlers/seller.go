@@ -2,31 +2,63 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/MdHisham-04/E-Commerce/internal/database"
 	"github.com/MdHisham-04/E-Commerce/internal/middleware"
 	"github.com/MdHisham-04/E-Commerce/internal/models"
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-// GetSellerProducts returns all products belonging to the authenticated seller
+// GetSellerProducts returns all products belonging to the authenticated seller,
+// optionally filtered by status
 func GetSellerProducts(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetUserFromContext(r)
 
+	query := database.DB.Scopes(productDetails).Where("seller_id = ?", claims.UserID)
+	if status := r.URL.Query().Get("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
 	var products []models.Product
-	result := database.DB.Where("seller_id = ?", claims.UserID).Order("created_at DESC").Find(&products)
+	result := query.Order("created_at DESC").Find(&products)
 
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
+	setImageURLs(products)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(products)
 }
 
+// GetSellerProduct returns one of the authenticated seller's products whatever
+// its status
+func GetSellerProduct(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Scopes(productDetails).Where("id = ? AND seller_id = ?", productID, claims.UserID).First(&product).Error; err != nil {
+		http.Error(w, "Product not found or access denied", http.StatusNotFound)
+		return
+	}
+	setImageURLs([]models.Product{product})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
 // GetAllOrders retrieves all orders containing the seller's products with filtered order items
 func GetAllOrders(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetUserFromContext(r)
@@ -36,9 +68,10 @@ func GetAllOrders(w http.ResponseWriter, r *http.Request) {
 		Joins("JOIN order_items ON order_items.order_id = orders.id").
 		Joins("JOIN products ON products.id = order_items.product_id").
 		Where("products.seller_id = ?", claims.UserID).
-		Preload("User").
+		Preload("User", withDeleted).
 		Preload("OrderItems", "product_id IN (SELECT id FROM products WHERE seller_id = ?)", claims.UserID).
-		Preload("OrderItems.Product").
+		Preload("OrderItems.Product", withDeleted).
+		Preload("OrderItems.Variant").
 		Group("orders.id").
 		Order("orders.created_at DESC").
 		Find(&orders)
@@ -61,9 +94,10 @@ func GetPendingOrders(w http.ResponseWriter, r *http.Request) {
 		Joins("JOIN order_items ON order_items.order_id = orders.id").
 		Joins("JOIN products ON products.id = order_items.product_id").
 		Where("products.seller_id = ? AND orders.status = ?", claims.UserID, "pending").
-		Preload("User").
+		Preload("User", withDeleted).
 		Preload("OrderItems", "product_id IN (SELECT id FROM products WHERE seller_id = ?)", claims.UserID).
-		Preload("OrderItems.Product").
+		Preload("OrderItems.Product", withDeleted).
+		Preload("OrderItems.Variant").
 		Group("orders.id").
 		Order("orders.created_at ASC").
 		Find(&orders)
@@ -117,7 +151,7 @@ func UpdateOrderItemStatus(w http.ResponseWriter, r *http.Request) {
 	database.DB.Model(&orderItem).Update("status", req.Status)
 
 	// Load updated order item with product
-	database.DB.Preload("Product").First(&orderItem, orderItemID)
+	database.DB.Preload("Product", withDeleted).Preload("Variant").First(&orderItem, orderItemID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(orderItem)
@@ -134,11 +168,35 @@ func CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	product.SellerID = claims.UserID
+	product.RatingAverage, product.RatingCount = 0, 0
 
-	if err := database.DB.Create(&product).Error; err != nil {
+	// New products go on sale straight away unless created as drafts
+	if product.Status == "" {
+		product.Status = ProductPublished
+	}
+	if product.Status != ProductPublished && product.Status != ProductDraft {
+		http.Error(w, "Status must be draft or published", http.StatusBadRequest)
+		return
+	}
+	if product.Status == ProductPublished {
+		if err := checkPublishable(product); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	product.SKU = normalizeSKU(product.SKU)
+	if product.SKU != nil && skuInUse(database.DB, *product.SKU, 0, 0) {
+		http.Error(w, "SKU already in use", http.StatusConflict)
+		return
+	}
+
+	// Categories, options, variants and images have their own endpoints, and the
+	// seller is the authenticated user
+	if err := database.DB.Omit("Seller", "Categories", "Options", "Variants", "Images").Create(&product).Error; err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	suggestions.Invalidate()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -174,6 +232,11 @@ func UpdateProductStock(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if variants, _ := hasVariants(database.DB, product.ID); variants {
+		http.Error(w, "Stock of this product is tracked per variant", http.StatusConflict)
+		return
+	}
+
 	product.Stock = req.Stock
 	database.DB.Save(&product)
 
@@ -203,6 +266,14 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if updates.SKU != nil {
+		// An empty SKU removes it
+		product.SKU = normalizeSKU(updates.SKU)
+		if product.SKU != nil && skuInUse(database.DB, *product.SKU, product.ID, 0) {
+			http.Error(w, "SKU already in use", http.StatusConflict)
+			return
+		}
+	}
 	if updates.Name != "" {
 		product.Name = updates.Name
 	}
@@ -217,12 +288,91 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 
 	database.DB.Save(&product)
+	if variants, _ := hasVariants(database.DB, product.ID); variants {
+		// Stock of variant products is the total of their variants
+		syncProductStock(database.DB, product.ID)
+		database.DB.First(&product, product.ID)
+	}
+	suggestions.Invalidate()
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(product)
 }
 
-// DeleteProduct removes a product owned by the authenticated seller
+// checkPublishable reports why a product cannot go on sale, if it cannot
+func checkPublishable(product models.Product) error {
+	if product.Name == "" || product.Price <= 0 {
+		return errors.New("a product needs a name and a price to be published")
+	}
+	return nil
+}
+
+// setProductStatus moves a product to a status. Products taken off sale are
+// removed from carts so they cannot be ordered.
+func setProductStatus(db *gorm.DB, product *models.Product, status string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if status != ProductPublished {
+			if err := tx.Where("product_id = ?", product.ID).Delete(&models.CartItem{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(product).Update("status", status).Error
+	})
+}
+
+// UpdateProductStatus moves a seller's product between draft, published and
+// archived. Archived products return to draft before they can be published again.
+func UpdateProductStatus(w http.ResponseWriter, r *http.Request) {
+	claims := middleware.GetUserFromContext(r)
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if _, ok := productTransitions[req.Status]; !ok {
+		http.Error(w, "Status must be draft, published or archived", http.StatusBadRequest)
+		return
+	}
+
+	var product models.Product
+	if err := database.DB.Where("id = ? AND seller_id = ?", productID, claims.UserID).First(&product).Error; err != nil {
+		http.Error(w, "Product not found or access denied", http.StatusNotFound)
+		return
+	}
+
+	if req.Status != product.Status {
+		if !slices.Contains(productTransitions[product.Status], req.Status) {
+			http.Error(w, fmt.Sprintf("A %s product cannot be %s", product.Status, req.Status), http.StatusConflict)
+			return
+		}
+		if req.Status == ProductPublished {
+			if err := checkPublishable(product); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := setProductStatus(database.DB, &product, req.Status); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		suggestions.Invalidate()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// DeleteProduct soft-deletes a product owned by the authenticated seller, so an
+// administrator can restore it until it is purged. Products that appear in
+// orders are archived instead, so order history keeps them.
 func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	claims := middleware.GetUserFromContext(r)
 	vars := mux.Vars(r)
@@ -232,13 +382,39 @@ func DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	result := database.DB.Where("id = ? AND seller_id = ?", productID, claims.UserID).Delete(&models.Product{})
-
-	if result.RowsAffected == 0 {
+	var product models.Product
+	if err := database.DB.Where("id = ? AND seller_id = ?", productID, claims.UserID).First(&product).Error; err != nil {
 		http.Error(w, "Product not found or access denied", http.StatusNotFound)
 		return
 	}
 
+	var orderItems int64
+	database.DB.Model(&models.OrderItem{}).Where("product_id = ?", product.ID).Count(&orderItems)
+	if orderItems > 0 {
+		if err := setProductStatus(database.DB, &product, ProductArchived); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		suggestions.Invalidate()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(product)
+		return
+	}
+
+	// The product, its images and the cart items are kept until PurgeDeletedRecords
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.CartItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&product).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	suggestions.Invalidate()
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -255,7 +431,8 @@ func GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 		LowStockProducts    int64   `json:"low_stock_products"`
 	}
 
-	database.DB.Model(&models.Product{}).Where("seller_id = ?", claims.UserID).Count(&stats.TotalProducts)
+	// Archived products are off sale for good and are left out of product counts
+	database.DB.Model(&models.Product{}).Where("seller_id = ? AND status <> ?", claims.UserID, ProductArchived).Count(&stats.TotalProducts)
 
 	// Count all order items for seller's products
 	database.DB.Model(&models.OrderItem{}).
@@ -276,7 +453,7 @@ func GetDashboardStats(w http.ResponseWriter, r *http.Request) {
 		Count(&stats.CompletedOrderItems)
 
 	database.DB.Model(&models.Product{}).
-		Where("seller_id = ? AND stock < ?", claims.UserID, 5).
+		Where("seller_id = ? AND status <> ? AND stock < ?", claims.UserID, ProductArchived, 5).
 		Count(&stats.LowStockProducts)
 
 	// Calculate revenue from completed order items
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/MdHisham-04/E-Commerce/internal/suggest"
+)
+
+const (
+	defaultSuggestions = 8
+	maxSuggestions     = 20
+)
+
+// suggestions indexes product and category names for autocomplete. Handlers that change
+// products invalidate it; the age limit covers changes made on other instances.
+var suggestions = suggest.New(loadSuggestions, time.Minute)
+
+func loadSuggestions() ([]suggest.Entry, error) {
+	var products []models.Product
+	if err := database.DB.Scopes(publishedProducts).Select("id", "name").Find(&products).Error; err != nil {
+		return nil, err
+	}
+
+	var categories []models.Category
+	if err := database.DB.Select("id", "name").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	entries := make([]suggest.Entry, 0, len(products)+len(categories))
+	for _, category := range categories {
+		entries = append(entries, suggest.Entry{ID: category.ID, Kind: "category", Text: category.Name})
+	}
+	for _, product := range products {
+		entries = append(entries, suggest.Entry{ID: product.ID, Kind: "product", Text: product.Name})
+	}
+	return entries, nil
+}
+
+// SuggestProducts returns autocomplete suggestions for q, prefix matches first
+// followed by close misspellings
+func SuggestProducts(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = defaultSuggestions
+	}
+	if limit > maxSuggestions {
+		limit = maxSuggestions
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(suggestions.Suggest(r.URL.Query().Get("q"), limit))
+}
@@ -4,22 +4,58 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
 	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
 	"github.com/MdHisham-04/E-Commerce/internal/models"
 	"github.com/gorilla/mux"
+	"gorm.io/gorm"
 )
 
-// GetUsers returns all users
+type CreateUserRequest struct {
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Password string   `json:"password"`
+	Roles    []string `json:"roles"`
+}
+
+// GetUsers returns users matching the optional q (email or name), role and
+// status (active or suspended) filters, paginated
 func GetUsers(w http.ResponseWriter, r *http.Request) {
+	query := database.DB.Model(&models.User{})
+
+	if q := r.URL.Query().Get("q"); q != "" {
+		like := containsPattern(q)
+		query = query.Where(`email ILIKE ? ESCAPE '\' OR name ILIKE ? ESCAPE '\'`, like, like)
+	}
+	if role := r.URL.Query().Get("role"); role != "" {
+		query = query.Where("id IN (SELECT user_roles.user_id FROM user_roles JOIN roles ON roles.id = user_roles.role_id WHERE roles.name = ?)", role)
+	}
+	switch r.URL.Query().Get("status") {
+	case "active":
+		query = query.Where("suspended_at IS NULL")
+	case "suspended":
+		query = query.Where("suspended_at IS NOT NULL")
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	page, perPage := parsePagination(r)
 	var users []models.User
-	result := database.DB.Find(&users)
+	result := query.Preload("Roles").Order("id ASC").Offset((page - 1) * perPage).Limit(perPage).Find(&users)
 
 	if result.Error != nil {
 		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	setPaginationHeaders(w, r, page, perPage, total)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
@@ -34,7 +70,7 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var user models.User
-	result := database.DB.First(&user, id)
+	result := database.DB.Preload("Roles").First(&user, id)
 
 	if result.Error != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -45,24 +81,62 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
-// CreateUser creates a new user
+// CreateUser creates a new user. Roles other than buyer can only be given by
+// callers who may change roles.
 func CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user models.User
+	var req CreateUserRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	// Basic validation
-	if user.Email == "" || user.Name == "" {
-		http.Error(w, "Email and name are required", http.StatusBadRequest)
+	if req.Email == "" || req.Name == "" || req.Password == "" {
+		http.Error(w, "Email, name, and password are required", http.StatusBadRequest)
 		return
 	}
 
-	result := database.DB.Create(&user)
-	if result.Error != nil {
-		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Roles) == 0 {
+		req.Roles = []string{"buyer"}
+	}
+
+	// Granting any other role is a role change and needs the same permission
+	for _, role := range req.Roles {
+		if role != "buyer" && !middleware.GetUserFromContext(r).HasPermission(auth.PermRoleWrite) {
+			http.Error(w, "Assigning roles other than buyer requires the "+auth.PermRoleWrite+" permission", http.StatusForbidden)
+			return
+		}
+	}
+
+	hashedPassword, err := auth.HashPassword(req.Password)
+	if err != nil {
+		http.Error(w, "Failed to process password", http.StatusInternalServerError)
+		return
+	}
+
+	// Accounts created by an administrator do not need to verify their email
+	now := time.Now()
+	user := models.User{
+		Email:           req.Email,
+		Name:            req.Name,
+		Password:        hashedPassword,
+		EmailVerifiedAt: &now,
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		return auth.AssignRoles(tx, &user, req.Roles...)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -70,3 +144,93 @@ func CreateUser(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(user)
 }
+
+// SuspendUser blocks a user from logging in and revokes their sessions, which
+// also rejects the access tokens already issued for them
+func SuspendUser(w http.ResponseWriter, r *http.Request) {
+	setUserSuspended(w, r, true)
+}
+
+// UnsuspendUser lifts a suspension
+func UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	setUserSuspended(w, r, false)
+}
+
+func setUserSuspended(w http.ResponseWriter, r *http.Request, suspended bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	var suspendedAt *time.Time
+	if suspended {
+		now := time.Now()
+		suspendedAt = &now
+	}
+
+	if err := database.DB.Model(&user).Update("suspended_at", suspendedAt).Error; err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if suspended {
+		if err := auth.RevokeAllRefreshTokens(user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// UpdateUserRoles replaces a user's roles. The first role becomes the primary
+// role. Changes apply from the user's next token refresh.
+func UpdateUserRoles(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Roles) == 0 {
+		http.Error(w, "At least one role is required", http.StatusBadRequest)
+		return
+	}
+
+	var user models.User
+	if err := database.DB.First(&user, id).Error; err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		return auth.AssignRoles(tx, &user, req.Roles...)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	database.DB.Preload("Roles").First(&user, id)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,412 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/middleware"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// maxProductOptions matches the Option1 to Option3 fields of a variant
+const maxProductOptions = 3
+
+type ProductOptionRequest struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type VariantRequest struct {
+	SKU     *string  `json:"sku"`
+	Option1 *string  `json:"option1"`
+	Option2 *string  `json:"option2"`
+	Option3 *string  `json:"option3"`
+	Price   *float64 `json:"price"`
+	Stock   *int     `json:"stock"`
+}
+
+// orderedOptions preloads product options in position order
+func orderedOptions(db *gorm.DB) *gorm.DB {
+	return db.Order("position ASC")
+}
+
+// orderedVariants preloads variants in the order they were added
+func orderedVariants(db *gorm.DB) *gorm.DB {
+	return db.Order("id ASC")
+}
+
+// syncProductStock sets the stock of a product to the total of its variants,
+// so listings and stock filters see variant products correctly
+func syncProductStock(tx *gorm.DB, productID int) error {
+	return tx.Exec(`UPDATE products SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = products.id)
+		WHERE id = ?`, productID).Error
+}
+
+// hasVariants reports whether a product is sold through variants
+func hasVariants(tx *gorm.DB, productID int) (bool, error) {
+	var count int64
+	err := tx.Model(&models.ProductVariant{}).Where("product_id = ?", productID).Count(&count).Error
+	return count > 0, err
+}
+
+// normalizeSKU trims a SKU, treating a blank one as none
+func normalizeSKU(sku *string) *string {
+	if sku == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*sku)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+// skuInUse reports whether a SKU belongs to a product or variant other than the
+// given ones. SKUs are unique across products and variants alike, and stay
+// taken by deleted products until they are purged.
+func skuInUse(db *gorm.DB, sku string, productID, variantID int) bool {
+	var products, variants int64
+	db.Unscoped().Model(&models.Product{}).Where("sku = ? AND id <> ?", sku, productID).Count(&products)
+	db.Model(&models.ProductVariant{}).Where("sku = ? AND id <> ?", sku, variantID).Count(&variants)
+	return products+variants > 0
+}
+
+func variantOptions(variant models.ProductVariant) [maxProductOptions]string {
+	return [maxProductOptions]string{variant.Option1, variant.Option2, variant.Option3}
+}
+
+// checkVariantOptions verifies that a variant has a valid value for each of the
+// product's options and none beyond them
+func checkVariantOptions(options []models.ProductOption, values [maxProductOptions]string) error {
+	for i, value := range values {
+		if i >= len(options) {
+			if value != "" {
+				return fmt.Errorf("product has no option %d", i+1)
+			}
+			continue
+		}
+
+		valid := false
+		for _, allowed := range options[i].Values {
+			if value == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf("invalid %s %q", options[i].Name, value)
+		}
+	}
+	return nil
+}
+
+// ownProduct loads a product of the authenticated seller along with its
+// options and variants. It writes the error response and returns false when
+// the product does not exist or belongs to someone else.
+func ownProduct(w http.ResponseWriter, r *http.Request) (models.Product, bool) {
+	claims := middleware.GetUserFromContext(r)
+
+	var product models.Product
+	productID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return product, false
+	}
+
+	err = database.DB.Preload("Options", orderedOptions).Preload("Variants", orderedVariants).
+		Where("id = ? AND seller_id = ?", productID, claims.UserID).First(&product).Error
+	if err != nil {
+		http.Error(w, "Product not found or access denied", http.StatusNotFound)
+		return product, false
+	}
+	return product, true
+}
+
+// ownVariant loads a variant of one of the authenticated seller's products
+func ownVariant(w http.ResponseWriter, r *http.Request) (models.Product, models.ProductVariant, bool) {
+	var variant models.ProductVariant
+
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return product, variant, false
+	}
+
+	variantID, err := strconv.Atoi(mux.Vars(r)["variant_id"])
+	if err != nil {
+		http.Error(w, "Invalid variant ID", http.StatusBadRequest)
+		return product, variant, false
+	}
+
+	for _, v := range product.Variants {
+		if v.ID == variantID {
+			return product, v, true
+		}
+	}
+	http.Error(w, "Variant not found", http.StatusNotFound)
+	return product, variant, false
+}
+
+// writeProduct responds with a product's current options and variants
+func writeProduct(w http.ResponseWriter, productID int) {
+	var product models.Product
+	database.DB.Preload("Options", orderedOptions).Preload("Variants", orderedVariants).First(&product, productID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(product)
+}
+
+// SetProductOptions replaces the option axes of a seller's product, such as
+// size and color. Existing variants must remain valid under the new options.
+func SetProductOptions(w http.ResponseWriter, r *http.Request) {
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var req []ProductOptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req) > maxProductOptions {
+		http.Error(w, fmt.Sprintf("A product can have at most %d options", maxProductOptions), http.StatusBadRequest)
+		return
+	}
+
+	options := make([]models.ProductOption, 0, len(req))
+	names := map[string]bool{}
+	for i, option := range req {
+		name := strings.TrimSpace(option.Name)
+		if name == "" || names[strings.ToLower(name)] {
+			http.Error(w, "Option names must be present and unique", http.StatusBadRequest)
+			return
+		}
+		names[strings.ToLower(name)] = true
+
+		values := make([]string, 0, len(option.Values))
+		seen := map[string]bool{}
+		for _, value := range option.Values {
+			value = strings.TrimSpace(value)
+			if value == "" || seen[value] {
+				http.Error(w, "Values of "+name+" must be present and unique", http.StatusBadRequest)
+				return
+			}
+			seen[value] = true
+			values = append(values, value)
+		}
+		if len(values) == 0 {
+			http.Error(w, name+" needs at least one value", http.StatusBadRequest)
+			return
+		}
+
+		options = append(options, models.ProductOption{ProductID: product.ID, Name: name, Position: i + 1, Values: values})
+	}
+
+	for _, variant := range product.Variants {
+		if err := checkVariantOptions(options, variantOptions(variant)); err != nil {
+			http.Error(w, "Variant "+variant.SKU+" would no longer be valid: "+err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("product_id = ?", product.ID).Delete(&models.ProductOption{}).Error; err != nil {
+			return err
+		}
+		if len(options) == 0 {
+			return nil
+		}
+		return tx.Create(&options).Error
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeProduct(w, product.ID)
+}
+
+// applyVariant copies the fields present in req onto variant and validates the
+// result
+func applyVariant(product models.Product, variant *models.ProductVariant, req VariantRequest) error {
+	if req.SKU != nil {
+		variant.SKU = strings.TrimSpace(*req.SKU)
+	}
+	fields := []*string{&variant.Option1, &variant.Option2, &variant.Option3}
+	for i, value := range []*string{req.Option1, req.Option2, req.Option3} {
+		if value != nil {
+			*fields[i] = strings.TrimSpace(*value)
+		}
+	}
+	if req.Price != nil {
+		variant.Price = *req.Price
+	}
+	if req.Stock != nil {
+		variant.Stock = *req.Stock
+	}
+
+	if variant.SKU == "" {
+		return errors.New("sku is required")
+	}
+	if variant.Price <= 0 {
+		return errors.New("price must be positive")
+	}
+	if variant.Stock < 0 {
+		return errors.New("stock cannot be negative")
+	}
+	if len(product.Options) == 0 {
+		return errors.New("add options to the product before creating variants")
+	}
+	if err := checkVariantOptions(product.Options, variantOptions(*variant)); err != nil {
+		return err
+	}
+
+	for _, other := range product.Variants {
+		if other.ID != variant.ID && variantOptions(other) == variantOptions(*variant) {
+			return errors.New("another variant already has these options: " + other.SKU)
+		}
+	}
+	return nil
+}
+
+// saveVariant stores a variant and updates its product's stock. The SKU must
+// be unique across all products and variants.
+func saveVariant(w http.ResponseWriter, variant *models.ProductVariant) bool {
+	if skuInUse(database.DB, variant.SKU, 0, variant.ID) {
+		http.Error(w, "SKU already in use", http.StatusConflict)
+		return false
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(variant).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, variant.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// CreateVariant adds a variant to a seller's product. The price defaults to the
+// product's price.
+func CreateVariant(w http.ResponseWriter, r *http.Request) {
+	product, ok := ownProduct(w, r)
+	if !ok {
+		return
+	}
+
+	var req VariantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	variant := models.ProductVariant{ProductID: product.ID, Price: product.Price}
+	if err := applyVariant(product, &variant, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !saveVariant(w, &variant) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(variant)
+}
+
+// UpdateVariant changes the fields present in the request
+func UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	product, variant, ok := ownVariant(w, r)
+	if !ok {
+		return
+	}
+
+	var req VariantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := applyVariant(product, &variant, req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !saveVariant(w, &variant) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variant)
+}
+
+// UpdateVariantStock sets the stock of a variant, for inventory integrations
+// holding only the product:stock permission
+func UpdateVariantStock(w http.ResponseWriter, r *http.Request) {
+	_, variant, ok := ownVariant(w, r)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Stock int `json:"stock"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Stock < 0 {
+		http.Error(w, "Stock cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	variant.Stock = req.Stock
+	if !saveVariant(w, &variant) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(variant)
+}
+
+// DeleteVariant removes a variant that has never been ordered, along with cart
+// items referencing it
+func DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	_, variant, ok := ownVariant(w, r)
+	if !ok {
+		return
+	}
+
+	var orderItems int64
+	database.DB.Model(&models.OrderItem{}).Where("variant_id = ?", variant.ID).Count(&orderItems)
+	if orderItems > 0 {
+		http.Error(w, "Variant has order history and cannot be deleted; set its stock to 0 instead", http.StatusConflict)
+		return
+	}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&variant).Error; err != nil {
+			return err
+		}
+		return syncProductStock(tx, variant.ProductID)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,132 @@
+package images
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Size is a thumbnail size; thumbnails fit within a square of MaxDimension pixels
+type Size struct {
+	Name         string
+	MaxDimension int
+}
+
+// ThumbnailSizes are generated for every uploaded image
+var ThumbnailSizes = []Size{
+	{Name: "small", MaxDimension: 160},
+	{Name: "medium", MaxDimension: 480},
+	{Name: "large", MaxDimension: 1024},
+}
+
+// MaxPixels bounds the decoded size of an image, so a small file cannot expand
+// into gigabytes of memory
+const MaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type, expected JPEG, PNG, GIF or WebP")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+var decoders = map[string]struct {
+	decode       func([]byte) (image.Image, error)
+	decodeConfig func([]byte) (image.Config, error)
+}{
+	"image/jpeg": {
+		func(b []byte) (image.Image, error) { return jpeg.Decode(bytes.NewReader(b)) },
+		func(b []byte) (image.Config, error) { return jpeg.DecodeConfig(bytes.NewReader(b)) },
+	},
+	"image/png": {
+		func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) },
+		func(b []byte) (image.Config, error) { return png.DecodeConfig(bytes.NewReader(b)) },
+	},
+	"image/gif": {
+		func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) },
+		func(b []byte) (image.Config, error) { return gif.DecodeConfig(bytes.NewReader(b)) },
+	},
+	"image/webp": {
+		func(b []byte) (image.Image, error) { return webp.Decode(bytes.NewReader(b)) },
+		func(b []byte) (image.Config, error) { return webp.DecodeConfig(bytes.NewReader(b)) },
+	},
+}
+
+// Decode identifies an image by its content rather than its file name or
+// declared type, and decodes it
+func Decode(data []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(data)
+	decoder, ok := decoders[contentType]
+	if !ok {
+		return nil, "", ErrUnsupportedType
+	}
+
+	config, err := decoder.decodeConfig(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > MaxPixels {
+		return nil, "", ErrTooManyPixels
+	}
+
+	img, err := decoder.decode(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid image: %w", err)
+	}
+	return img, contentType, nil
+}
+
+// Thumbnail scales an image down to fit within maxDimension pixels, keeping
+// its aspect ratio. Smaller images are returned unchanged.
+func Thumbnail(img image.Image, maxDimension int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxDimension && height <= maxDimension {
+		return img
+	}
+
+	if width >= height {
+		height = max(1, height*maxDimension/width)
+		width = maxDimension
+	} else {
+		width = max(1, width*maxDimension/height)
+		height = maxDimension
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Src, nil)
+	return thumbnail
+}
+
+// Encode writes a thumbnail as JPEG, or as PNG when it has transparent pixels.
+// It returns the encoded image, its content type and file extension.
+func Encode(img image.Image) ([]byte, string, string, error) {
+	var buf bytes.Buffer
+	if opaque, ok := img.(interface{ Opaque() bool }); ok && !opaque.Opaque() {
+		err := png.Encode(&buf, img)
+		return buf.Bytes(), "image/png", "png", err
+	}
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), "image/jpeg", "jpg", err
+}
+
+// Extension returns the file extension for a supported content type
+func Extension(contentType string) string {
+	switch contentType {
+	case "image/jpeg":
+		return "jpg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	}
+	return "bin"
+}
@@ -0,0 +1,182 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/database"
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+// MaxAttempts is how many times a failing job runs before it is marked failed
+const MaxAttempts = 3
+
+// staleAfter is how long a job may run before it is assumed lost, for example
+// because its instance crashed, and is queued again
+const staleAfter = 30 * time.Minute
+
+// Handler runs a job and returns a result to store with it
+type Handler func(ctx context.Context, job models.Job) (result string, err error)
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string]Handler{}
+)
+
+// Register sets the handler for a job type
+func Register(jobType string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[jobType] = handler
+}
+
+// Enqueue queues a job of the given type about a user. payload, if not nil, is
+// stored as JSON and can be read back with DecodePayload.
+func Enqueue(jobType string, userID int, payload interface{}) (models.Job, error) {
+	job := models.Job{
+		Type:   jobType,
+		UserID: userID,
+		Status: StatusQueued,
+		RunAt:  time.Now(),
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return job, err
+		}
+		job.Payload = string(data)
+	}
+
+	err := database.DB.Create(&job).Error
+	return job, err
+}
+
+// DecodePayload unmarshals the job's payload into v
+func DecodePayload(job models.Job, v interface{}) error {
+	if job.Payload == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(job.Payload), v)
+}
+
+// SetProgress records how much of a job's work is done, for clients polling it
+func SetProgress(jobID, done, total int) error {
+	return database.DB.Model(&models.Job{}).Where("id = ?", jobID).
+		Updates(map[string]interface{}{"progress": done, "total": total}).Error
+}
+
+// Start polls for due jobs and runs them one at a time. Several instances may
+// run workers against the same database.
+func Start(interval time.Duration) {
+	for {
+		if err := requeueStale(); err != nil {
+			log.Println("Failed to requeue stale jobs:", err)
+		}
+
+		// Drain every due job before sleeping
+		for {
+			job, ok, err := claim()
+			if err != nil {
+				log.Println("Failed to claim job:", err)
+				break
+			}
+			if !ok {
+				break
+			}
+			run(job)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// claim marks the oldest due job as running and returns it. SKIP LOCKED lets
+// concurrent workers claim different jobs.
+func claim() (models.Job, bool, error) {
+	var jobs []models.Job
+	err := database.DB.Raw(`
+		UPDATE jobs SET status = ?, started_at = ?, attempts = attempts + 1
+		WHERE id = (
+			SELECT id FROM jobs WHERE status = ? AND run_at <= ?
+			ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *`, StatusRunning, time.Now(), StatusQueued, time.Now()).Scan(&jobs).Error
+	if err != nil || len(jobs) == 0 {
+		return models.Job{}, false, err
+	}
+	return jobs[0], true, nil
+}
+
+func run(job models.Job) {
+	mu.RLock()
+	handler, ok := handlers[job.Type]
+	mu.RUnlock()
+
+	var result string
+	var err error
+	if !ok {
+		err = fmt.Errorf("no handler registered for job type %q", job.Type)
+	} else {
+		result, err = safeRun(handler, job)
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{"finished_at": now, "result": result, "error": ""}
+	switch {
+	case err == nil:
+		updates["status"] = StatusSucceeded
+	case job.Attempts < MaxAttempts && ok:
+		// Back off quadratically: 1, 4, 9 minutes...
+		updates["status"] = StatusQueued
+		updates["run_at"] = now.Add(time.Duration(job.Attempts*job.Attempts) * time.Minute)
+		updates["error"] = err.Error()
+		updates["finished_at"] = nil
+	default:
+		updates["status"] = StatusFailed
+		updates["error"] = err.Error()
+	}
+	if err != nil {
+		log.Printf("Job %d (%s) attempt %d failed: %v", job.ID, job.Type, job.Attempts, err)
+	}
+
+	if err := database.DB.Model(&models.Job{}).Where("id = ?", job.ID).Updates(updates).Error; err != nil {
+		log.Printf("Failed to record result of job %d: %v", job.ID, err)
+	}
+}
+
+// safeRun turns a panicking handler into a failed attempt
+func safeRun(handler Handler, job models.Job) (result string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), staleAfter)
+	defer cancel()
+	return handler(ctx, job)
+}
+
+func requeueStale() error {
+	return database.DB.Model(&models.Job{}).
+		Where("status = ? AND started_at < ?", StatusRunning, time.Now().Add(-staleAfter)).
+		Updates(map[string]interface{}{"status": StatusQueued, "run_at": time.Now()}).Error
+}
+
+// Purge deletes finished jobs older than maxAge
+func Purge(maxAge time.Duration) error {
+	return database.DB.Where("status IN ? AND finished_at < ?", []string{StatusSucceeded, StatusFailed}, time.Now().Add(-maxAge)).
+		Delete(&models.Job{}).Error
+}
@@ -0,0 +1,133 @@
+package lockout
+
+import (
+	"time"
+)
+
+// Attempts is the failed login history of one key, such as an account or an IP address
+type Attempts struct {
+	Failures      int
+	LastFailureAt time.Time
+	// PreviousFailureAt is LastFailureAt before the latest Increment, zero when
+	// there was no failure within the window
+	PreviousFailureAt time.Time
+	LockedUntil       time.Time
+}
+
+// Store persists failed attempts. Implementations must make Increment atomic so
+// that several API instances can share one store.
+type Store interface {
+	Get(key string) (Attempts, error)
+	// Increment records a failure at now. Failures older than window are forgotten first.
+	Increment(key string, now time.Time, window time.Duration) (Attempts, error)
+	// Release undoes the Increment made at now: one failure fewer, and the last
+	// failure time back to previous unless a later failure has replaced it
+	Release(key string, now, previous time.Time) error
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+	// Purge drops keys with no failure since before
+	Purge(before time.Time) error
+}
+
+// Policy controls how quickly failures are throttled
+type Policy struct {
+	FreeAttempts     int           // failures allowed before backoff starts
+	BaseDelay        time.Duration // delay after the first throttled failure, doubled for each one after
+	MaxDelay         time.Duration
+	LockoutThreshold int // failures that trigger a lockout, 0 disables lockout
+	LockoutDuration  time.Duration
+	Window           time.Duration // failures older than this are forgotten
+}
+
+// Limiter applies a Policy to keys held in a Store
+type Limiter struct {
+	store  Store
+	policy Policy
+}
+
+// New creates a Limiter
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{store: store, policy: policy}
+}
+
+// delay returns the exponential backoff that applies after the given number of failures
+func (l *Limiter) delay(failures int) time.Duration {
+	over := failures - l.policy.FreeAttempts
+	if over <= 0 {
+		return 0
+	}
+	d := l.policy.BaseDelay
+	for i := 1; i < over && d < l.policy.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > l.policy.MaxDelay {
+		d = l.policy.MaxDelay
+	}
+	return d
+}
+
+// Attempt is an attempt reserved by Reserve
+type Attempt struct {
+	Key string
+	// Wait is how long the caller must wait before trying again when the
+	// attempt was refused, and zero when it may go ahead
+	Wait time.Duration
+	// Locked reports that the attempt was refused because the key is locked out
+	// rather than merely backed off
+	Locked bool
+	// NewlyLocked reports that the attempt reached the lockout threshold, so the
+	// key is locked out should it fail
+	NewlyLocked bool
+
+	at, previous time.Time
+}
+
+// Reserve counts an attempt for key as a failure before the credentials are
+// checked, so that concurrent attempts cannot all pass a check made before any
+// of them failed. Refused attempts are released straight away. An attempt that
+// goes ahead and succeeds must be given back with Release or Reset.
+func (l *Limiter) Reserve(key string) (Attempt, error) {
+	now := time.Now()
+	a, err := l.store.Increment(key, now, l.policy.Window)
+	if err != nil {
+		return Attempt{}, err
+	}
+	attempt := Attempt{Key: key, at: now, previous: a.PreviousFailureAt}
+
+	if now.Before(a.LockedUntil) {
+		attempt.Wait, attempt.Locked = a.LockedUntil.Sub(now), true
+	} else if !a.PreviousFailureAt.IsZero() {
+		// Backoff follows the failures before this attempt
+		if next := a.PreviousFailureAt.Add(l.delay(a.Failures - 1)); now.Before(next) {
+			attempt.Wait = next.Sub(now)
+		}
+	}
+	if attempt.Wait > 0 {
+		// Refused attempts are not failures, so they do not extend the backoff
+		return attempt, l.store.Release(key, now, a.PreviousFailureAt)
+	}
+
+	if l.policy.LockoutThreshold > 0 && a.Failures >= l.policy.LockoutThreshold {
+		if err := l.store.Lock(key, now.Add(l.policy.LockoutDuration)); err != nil {
+			return attempt, err
+		}
+		attempt.NewlyLocked = true
+	}
+	return attempt, nil
+}
+
+// Release gives back an attempt that did not fail while keeping the other
+// failures of its key, such as those of other accounts behind one IP address
+func (l *Limiter) Release(attempt Attempt) error {
+	return l.store.Release(attempt.Key, attempt.at, attempt.previous)
+}
+
+// Reset clears the failure history for key, after a successful login or an unlock
+func (l *Limiter) Reset(key string) error {
+	return l.store.Reset(key)
+}
+
+// Purge drops history that no longer affects any decision
+func (l *Limiter) Purge() error {
+	return l.store.Purge(time.Now().Add(-l.policy.Window - l.policy.LockoutDuration))
+}
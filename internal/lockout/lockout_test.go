@@ -0,0 +1,99 @@
+package lockout
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+var policy = Policy{
+	FreeAttempts:     3,
+	BaseDelay:        time.Minute,
+	MaxDelay:         time.Hour,
+	LockoutThreshold: 10,
+	LockoutDuration:  time.Hour,
+	Window:           time.Hour,
+}
+
+func TestReserveParallelBurst(t *testing.T) {
+	l := New(NewMemoryStore(), policy)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, err := l.Reserve("account:a@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if attempt.Wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The free attempts plus the one that starts the backoff
+	if allowed != policy.FreeAttempts+1 {
+		t.Errorf("allowed %d attempts, want %d", allowed, policy.FreeAttempts+1)
+	}
+}
+
+func TestReserveRefusedDoesNotCount(t *testing.T) {
+	store := NewMemoryStore()
+	l := New(store, policy)
+
+	for range policy.FreeAttempts + 1 {
+		if attempt, _ := l.Reserve("ip:1"); attempt.Wait > 0 {
+			t.Fatal("free attempt refused")
+		}
+	}
+	for range 5 {
+		if attempt, _ := l.Reserve("ip:1"); attempt.Wait == 0 {
+			t.Fatal("attempt allowed during backoff")
+		}
+	}
+
+	a, _ := store.Get("ip:1")
+	if a.Failures != policy.FreeAttempts+1 {
+		t.Errorf("failures = %d, want %d", a.Failures, policy.FreeAttempts+1)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	store := NewMemoryStore()
+	l := New(store, policy)
+
+	first, _ := l.Reserve("ip:1")
+	second, _ := l.Reserve("ip:1")
+	if err := l.Release(second); err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := store.Get("ip:1")
+	if a.Failures != 1 || !a.LastFailureAt.Equal(first.at) {
+		t.Errorf("after release got %d failures at %v, want 1 at %v", a.Failures, a.LastFailureAt, first.at)
+	}
+}
+
+func TestReserveLocksAtThreshold(t *testing.T) {
+	l := New(NewMemoryStore(), Policy{LockoutThreshold: 2, LockoutDuration: time.Hour, Window: time.Hour})
+
+	if attempt, _ := l.Reserve("account:a"); attempt.NewlyLocked {
+		t.Fatal("locked below threshold")
+	}
+	if attempt, _ := l.Reserve("account:a"); attempt.Wait > 0 || !attempt.NewlyLocked {
+		t.Fatalf("attempt at threshold = %+v, want allowed and newly locked", attempt)
+	}
+	if attempt, _ := l.Reserve("account:a"); !attempt.Locked {
+		t.Errorf("attempt after lockout = %+v, want locked", attempt)
+	}
+}
@@ -0,0 +1,83 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps attempts in process memory. It suits a single instance and tests.
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempts
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{attempts: map[string]Attempts{}}
+}
+
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryStore) Increment(key string, now time.Time, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.attempts[key]
+	if now.Sub(a.LastFailureAt) > window {
+		a.Failures = 0
+		a.LastFailureAt = time.Time{}
+	}
+	a.Failures++
+	a.PreviousFailureAt = a.LastFailureAt
+	a.LastFailureAt = now
+	s.attempts[key] = a
+	return a, nil
+}
+
+func (s *MemoryStore) Release(key string, now, previous time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a, ok := s.attempts[key]
+	if !ok {
+		return nil
+	}
+	a.Failures = max(a.Failures-1, 0)
+	if a.LastFailureAt.Equal(now) {
+		a.LastFailureAt = previous
+	}
+	s.attempts[key] = a
+	return nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.attempts[key]
+	a.LockedUntil = until
+	s.attempts[key] = a
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
+
+func (s *MemoryStore) Purge(before time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, a := range s.attempts {
+		if a.LastFailureAt.Before(before) && a.LockedUntil.Before(before) {
+			delete(s.attempts, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package lockout
+
+import (
+	"errors"
+	"time"
+
+	"github.com/MdHisham-04/E-Commerce/internal/models"
+	"gorm.io/gorm"
+)
+
+// PostgresStore keeps attempts in the login_attempts table so every instance shares them
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresStore creates a store backed by db
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func toAttempts(row models.LoginAttempt) Attempts {
+	a := Attempts{Failures: row.Failures, LastFailureAt: row.LastFailureAt}
+	if row.PreviousFailureAt != nil {
+		a.PreviousFailureAt = *row.PreviousFailureAt
+	}
+	if row.LockedUntil != nil {
+		a.LockedUntil = *row.LockedUntil
+	}
+	return a
+}
+
+func (s *PostgresStore) Get(key string) (Attempts, error) {
+	var row models.LoginAttempt
+	err := s.db.Where("key = ?", key).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return Attempts{}, nil
+	}
+	if err != nil {
+		return Attempts{}, err
+	}
+	return toAttempts(row), nil
+}
+
+// Postgres stores microseconds; times are truncated so Release can compare them
+func (s *PostgresStore) Increment(key string, now time.Time, window time.Duration) (Attempts, error) {
+	now = now.Truncate(time.Microsecond)
+	var row models.LoginAttempt
+	err := s.db.Raw(`
+		INSERT INTO login_attempts (key, failures, last_failure_at)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END,
+			previous_failure_at = CASE WHEN login_attempts.last_failure_at < ? THEN NULL ELSE login_attempts.last_failure_at END,
+			last_failure_at = EXCLUDED.last_failure_at
+		RETURNING *`,
+		key, now, now.Add(-window), now.Add(-window)).Scan(&row).Error
+	if err != nil {
+		return Attempts{}, err
+	}
+	return toAttempts(row), nil
+}
+
+func (s *PostgresStore) Release(key string, now, previous time.Time) error {
+	return s.db.Exec(`
+		UPDATE login_attempts SET
+			failures = GREATEST(failures - 1, 0),
+			last_failure_at = CASE WHEN last_failure_at = ? THEN ? ELSE last_failure_at END
+		WHERE key = ?`,
+		now.Truncate(time.Microsecond), previous, key).Error
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	return s.db.Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	return s.db.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (s *PostgresStore) Purge(before time.Time) error {
+	return s.db.Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", before, before).
+		Delete(&models.LoginAttempt{}).Error
+}
@@ -12,22 +12,33 @@ type contextKey string
 
 const UserContextKey contextKey = "user"
 
-// AuthMiddleware validates JWT tokens
+// AuthMiddleware authenticates requests with a JWT bearer token or a seller API
+// key, sent as "Authorization: ApiKey <key>" or in the X-API-Key header
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := r.Header.Get("X-API-Key"); key != "" {
+			authenticateAPIKey(w, r, next, key)
+			return
+		}
+
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			http.Error(w, "Authorization header required", http.StatusUnauthorized)
 			return
 		}
 
-		// Extract token from "Bearer <token>"
+		// Extract credentials from "Bearer <token>" or "ApiKey <key>"
 		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		if len(parts) != 2 || (parts[0] != "Bearer" && parts[0] != "ApiKey") {
 			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
 			return
 		}
 
+		if parts[0] == "ApiKey" {
+			authenticateAPIKey(w, r, next, parts[1])
+			return
+		}
+
 		token := parts[1]
 		claims, err := auth.ValidateToken(token)
 		if err != nil {
@@ -35,24 +46,64 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		if claims.ImpersonatorID != 0 && !checkImpersonation(w, r, claims) {
+			return
+		}
+
 		// Add claims to request context
 		ctx := context.WithValue(r.Context(), UserContextKey, claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	if !auth.LooksLikeAPIKey(key) {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := auth.ValidateAPIKey(key)
+	if err != nil {
+		http.Error(w, "Invalid, expired or revoked API key", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), UserContextKey, claims)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// DenyAPIKeys middleware restricts routes to interactive user sessions
+func DenyAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims := GetUserFromContext(r); claims != nil && claims.APIKeyID != 0 {
+			http.Error(w, "API keys cannot access this resource", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 // RequireRole middleware checks if user has required role
 func RequireRole(role string) func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			claims := r.Context().Value(UserContextKey).(*auth.Claims)
-			if claims.Role != role {
-				http.Error(w, "Insufficient permissions", http.StatusForbidden)
-				return
-			}
-			next.ServeHTTP(w, r)
-		})
-	}
+	return Authorize(HasRole(role))
+}
+
+// RequirePermission middleware checks if user holds the required permission
+func RequirePermission(permission string) func(http.Handler) http.Handler {
+	return Authorize(HasPermission(permission))
+}
+
+// RequireMFA middleware rejects tokens that were issued without a second factor.
+// API keys pass because minting one already requires an MFA session under this policy.
+func RequireMFA(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims := GetUserFromContext(r)
+		if claims == nil || (!claims.MFA && claims.APIKeyID == 0) {
+			http.Error(w, "Two-factor authentication required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // GetUserFromContext extracts user claims from request context
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is the number of reverse proxies in front of the API that append
+// to X-Forwarded-For. ClientIP ignores the header when it is 0.
+var TrustedProxies = 0
+
+// ClientIP returns the IP address the request originated from. Behind trusted
+// proxies it is the entry the outermost one added to X-Forwarded-For; entries
+// to the left of it come from the client and cannot be trusted.
+func ClientIP(r *http.Request) string {
+	if TrustedProxies > 0 {
+		var hops []string
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, hop := range strings.Split(header, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		// A shorter header means the request did not pass through every proxy
+		if len(hops) >= TrustedProxies {
+			if ip := net.ParseIP(hops[len(hops)-TrustedProxies]); ip != nil {
+				return ip.String()
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		proxies   int
+		forwarded []string
+		want      string
+	}{
+		{"no proxy", 0, nil, "10.0.0.1"},
+		{"no proxy ignores header", 0, []string{"203.0.113.9"}, "10.0.0.1"},
+		{"one proxy", 1, []string{"203.0.113.9"}, "203.0.113.9"},
+		{"one proxy spoofed", 1, []string{"198.51.100.7, 203.0.113.9"}, "203.0.113.9"},
+		{"one proxy spoofed in separate header", 1, []string{"198.51.100.7", "203.0.113.9"}, "203.0.113.9"},
+		{"two proxies", 2, []string{"198.51.100.7, 203.0.113.9, 10.0.0.2"}, "203.0.113.9"},
+		{"missing header", 1, nil, "10.0.0.1"},
+		{"header shorter than proxy chain", 2, []string{"198.51.100.7"}, "10.0.0.1"},
+		{"invalid entry", 1, []string{"198.51.100.7, not-an-ip"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := TrustedProxies
+			TrustedProxies = tt.proxies
+			defer func() { TrustedProxies = previous }()
+
+			r := httptest.NewRequest(http.MethodPost, "/api/login", nil)
+			r.RemoteAddr = "10.0.0.1:54321"
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := ClientIP(r); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
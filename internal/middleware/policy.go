@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+// Policy decides whether the authenticated user may access a request
+type Policy interface {
+	Allow(claims *auth.Claims, r *http.Request) bool
+}
+
+// PolicyFunc adapts an ordinary function to the Policy interface
+type PolicyFunc func(claims *auth.Claims, r *http.Request) bool
+
+// Allow calls f(claims, r)
+func (f PolicyFunc) Allow(claims *auth.Claims, r *http.Request) bool {
+	return f(claims, r)
+}
+
+// Authorize middleware rejects requests that are not allowed by the given policy
+func Authorize(policy Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := GetUserFromContext(r)
+			if claims == nil {
+				http.Error(w, "Authentication required", http.StatusUnauthorized)
+				return
+			}
+			if !policy.Allow(claims, r) {
+				http.Error(w, "Insufficient permissions", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSelfOrRole allows access when the path variable param matches the
+// authenticated user's ID, or when the user holds one of the given roles
+func RequireSelfOrRole(param string, roles ...string) func(http.Handler) http.Handler {
+	return Authorize(AnyOf(Self(param), HasRole(roles...)))
+}
+
+// RequireSelfOrPermission allows access when the path variable param matches the
+// authenticated user's ID, or when the user holds the given permission
+func RequireSelfOrPermission(param, permission string) func(http.Handler) http.Handler {
+	return Authorize(AnyOf(Self(param), HasPermission(permission)))
+}
+
+// RequireSelfWithPermission allows access only to the user named by the path
+// variable param, and only when they also hold the given permission
+func RequireSelfWithPermission(param, permission string) func(http.Handler) http.Handler {
+	return Authorize(AllOf(Self(param), HasPermission(permission)))
+}
+
+// Self allows access when the path variable param equals the authenticated user's
+// ID. API keys never count as the user themselves.
+func Self(param string) Policy {
+	return PolicyFunc(func(claims *auth.Claims, r *http.Request) bool {
+		if claims.APIKeyID != 0 {
+			return false
+		}
+		id, err := strconv.Atoi(mux.Vars(r)[param])
+		if err != nil {
+			return false
+		}
+		return id == claims.UserID
+	})
+}
+
+// HasRole allows access when the authenticated user holds any of the given roles
+func HasRole(roles ...string) Policy {
+	return PolicyFunc(func(claims *auth.Claims, r *http.Request) bool {
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// HasPermission allows access when the authenticated user holds the permission
+func HasPermission(permission string) Policy {
+	return PolicyFunc(func(claims *auth.Claims, r *http.Request) bool {
+		return claims.HasPermission(permission)
+	})
+}
+
+// AnyOf allows access when at least one of the policies allows it
+func AnyOf(policies ...Policy) Policy {
+	return PolicyFunc(func(claims *auth.Claims, r *http.Request) bool {
+		for _, p := range policies {
+			if p.Allow(claims, r) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AllOf allows access only when every policy allows it
+func AllOf(policies ...Policy) Policy {
+	return PolicyFunc(func(claims *auth.Claims, r *http.Request) bool {
+		for _, p := range policies {
+			if !p.Allow(claims, r) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MdHisham-04/E-Commerce/internal/auth"
+	"github.com/gorilla/mux"
+)
+
+var (
+	buyer   = &auth.Claims{UserID: 1, Role: "buyer", Permissions: []string{auth.PermCartWrite}}
+	other   = &auth.Claims{UserID: 2, Role: "buyer", Permissions: []string{auth.PermCartWrite}}
+	apiKey  = &auth.Claims{UserID: 1, Role: "seller", Permissions: []string{auth.PermCartWrite}, APIKeyID: 7}
+	support = &auth.Claims{UserID: 3, Role: "support", Permissions: []string{auth.PermCartReadAny}}
+	admin   = &auth.Claims{UserID: 4, Role: "admin"}
+)
+
+// requestFor builds a request for /users/{user_id} with the claims in its context
+func requestFor(claims *auth.Claims, userID string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/users/"+userID, nil)
+	r = mux.SetURLVars(r, map[string]string{"user_id": userID})
+	if claims != nil {
+		r = r.WithContext(context.WithValue(r.Context(), UserContextKey, claims))
+	}
+	return r
+}
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		claims *auth.Claims
+		userID string
+		want   bool
+	}{
+		{"self own id", Self("user_id"), buyer, "1", true},
+		{"self other user's id", Self("user_id"), other, "1", false},
+		{"self api key", Self("user_id"), apiKey, "1", false},
+		{"self invalid id", Self("user_id"), buyer, "abc", false},
+		{"self staff", Self("user_id"), support, "1", false},
+
+		{"has role", HasRole("support", "admin"), support, "1", true},
+		{"has role missing", HasRole("support", "admin"), buyer, "1", false},
+
+		{"has permission", HasPermission(auth.PermCartReadAny), support, "1", true},
+		{"has permission missing", HasPermission(auth.PermCartReadAny), buyer, "1", false},
+
+		{"any of own id", AnyOf(Self("user_id"), HasPermission(auth.PermCartReadAny)), buyer, "1", true},
+		{"any of other user's id", AnyOf(Self("user_id"), HasPermission(auth.PermCartReadAny)), other, "1", false},
+		{"any of api key", AnyOf(Self("user_id"), HasPermission(auth.PermCartReadAny)), apiKey, "1", false},
+		{"any of staff permission", AnyOf(Self("user_id"), HasPermission(auth.PermCartReadAny)), support, "1", true},
+		{"any of none", AnyOf(), buyer, "1", false},
+
+		{"all of own id", AllOf(Self("user_id"), HasPermission(auth.PermCartWrite)), buyer, "1", true},
+		{"all of other user's id", AllOf(Self("user_id"), HasPermission(auth.PermCartWrite)), other, "1", false},
+		{"all of api key", AllOf(Self("user_id"), HasPermission(auth.PermCartWrite)), apiKey, "1", false},
+		{"all of staff permission", AllOf(Self("user_id"), HasPermission(auth.PermCartWrite)), support, "1", false},
+		{"all of none", AllOf(), buyer, "1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Allow(tt.claims, requestFor(tt.claims, tt.userID)); got != tt.want {
+				t.Errorf("Allow() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequireSelf(t *testing.T) {
+	selfOrRole := RequireSelfOrRole("user_id", "admin")
+	selfOrPermission := RequireSelfOrPermission("user_id", auth.PermCartReadAny)
+	selfWithPermission := RequireSelfWithPermission("user_id", auth.PermCartWrite)
+
+	tests := []struct {
+		name       string
+		middleware func(http.Handler) http.Handler
+		claims     *auth.Claims
+		userID     string
+		want       int
+	}{
+		{"self or role own id", selfOrRole, buyer, "1", http.StatusOK},
+		{"self or role other user's id", selfOrRole, other, "1", http.StatusForbidden},
+		{"self or role api key", selfOrRole, apiKey, "1", http.StatusForbidden},
+		{"self or role staff", selfOrRole, admin, "1", http.StatusOK},
+		{"self or role anonymous", selfOrRole, nil, "1", http.StatusUnauthorized},
+
+		{"self or permission own id", selfOrPermission, buyer, "1", http.StatusOK},
+		{"self or permission other user's id", selfOrPermission, other, "1", http.StatusForbidden},
+		{"self or permission api key", selfOrPermission, apiKey, "1", http.StatusForbidden},
+		{"self or permission staff", selfOrPermission, support, "1", http.StatusOK},
+		{"self or permission anonymous", selfOrPermission, nil, "1", http.StatusUnauthorized},
+
+		{"self with permission own id", selfWithPermission, buyer, "1", http.StatusOK},
+		{"self with permission other user's id", selfWithPermission, other, "1", http.StatusForbidden},
+		{"self with permission api key", selfWithPermission, apiKey, "1", http.StatusForbidden},
+		{"self with permission staff", selfWithPermission, support, "1", http.StatusForbidden},
+		{"self with permission anonymous", selfWithPermission, nil, "1", http.StatusUnauthorized},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.middleware(ok).ServeHTTP(rec, requestFor(tt.claims, tt.userID))
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
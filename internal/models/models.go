@@ -2,38 +2,169 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
 type User struct {
+	ID              int            `json:"id" gorm:"primaryKey"`
+	Email           string         `json:"email" gorm:"unique;not null"`
+	Name            string         `json:"name"`
+	Password        string         `json:"-" gorm:"not null"`
+	Role            string         `json:"role" gorm:"default:'buyer'"`
+	EmailVerifiedAt *time.Time     `json:"email_verified_at"`
+	PendingEmail    string         `json:"pending_email,omitempty"`
+	MFAEnabled      bool           `json:"mfa_enabled" gorm:"default:false"`
+	TOTPSecret      string         `json:"-"`
+	TOTPLastStep    int64          `json:"-"`
+	SuspendedAt     *time.Time     `json:"suspended_at"`
+	AnonymizedAt    *time.Time     `json:"anonymized_at,omitempty"`
+	Roles           []Role         `json:"roles,omitempty" gorm:"many2many:user_roles"`
+	CreatedAt       time.Time      `json:"created_at"`
+	DeletedAt       gorm.DeletedAt `json:"deleted_at,omitzero" gorm:"index"`
+}
+
+// Role groups permissions; a user may hold several roles
+type Role struct {
+	ID          int          `json:"id" gorm:"primaryKey"`
+	Name        string       `json:"name" gorm:"uniqueIndex;not null"`
+	Permissions []Permission `json:"permissions" gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+type Permission struct {
+	ID   int    `json:"id" gorm:"primaryKey"`
+	Name string `json:"name" gorm:"uniqueIndex;not null"`
+}
+
+type Product struct {
+	ID            int              `json:"id" gorm:"primaryKey"`
+	SKU           *string          `json:"sku,omitempty" gorm:"uniqueIndex"`
+	Name          string           `json:"name" gorm:"not null"`
+	Description   string           `json:"description"`
+	Price         float64          `json:"price" gorm:"not null"`
+	Stock         int              `json:"stock" gorm:"default:0"`
+	Status        string           `json:"status" gorm:"not null;default:'published';index"`
+	RatingAverage float64          `json:"rating_average" gorm:"not null;default:0"`
+	RatingCount   int              `json:"rating_count" gorm:"not null;default:0"`
+	SellerID      int              `json:"seller_id" gorm:"not null"`
+	Seller        SellerProfile    `json:"seller,omitempty" gorm:"foreignKey:SellerID"`
+	Categories    []Category       `json:"categories,omitempty" gorm:"many2many:product_categories;constraint:OnDelete:CASCADE"`
+	Options       []ProductOption  `json:"options,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	Variants      []ProductVariant `json:"variants,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	Images        []ProductImage   `json:"images,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	Reviews       []Review         `json:"-" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt     time.Time        `json:"created_at"`
+	DeletedAt     gorm.DeletedAt   `json:"deleted_at,omitzero" gorm:"index"`
+}
+
+// SellerProfile is the public view of the user selling a product. Account
+// details such as email, MFA or suspension state stay out of product responses.
+type SellerProfile struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func (SellerProfile) TableName() string {
+	return "users"
+}
+
+// ProductOption is an axis along which a product's variants differ, such as
+// size or color. Position 1 to 3 selects the matching Option field of each variant.
+type ProductOption struct {
+	ID        int      `json:"id" gorm:"primaryKey"`
+	ProductID int      `json:"product_id" gorm:"not null;uniqueIndex:idx_option_product_position"`
+	Name      string   `json:"name" gorm:"not null"`
+	Position  int      `json:"position" gorm:"not null;uniqueIndex:idx_option_product_position"`
+	Values    []string `json:"values" gorm:"serializer:json"`
+}
+
+// ProductVariant is a purchasable combination of option values with its own
+// SKU, price and stock. A product with variants is sold only through them, and
+// its Stock is kept equal to their total.
+type ProductVariant struct {
 	ID        int       `json:"id" gorm:"primaryKey"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Name      string    `json:"name"`
-	Password  string    `json:"-" gorm:"not null"`
-	Role      string    `json:"role" gorm:"default:'buyer'"`
+	ProductID int       `json:"product_id" gorm:"not null;index"`
+	SKU       string    `json:"sku" gorm:"uniqueIndex;not null"`
+	Option1   string    `json:"option1,omitempty"`
+	Option2   string    `json:"option2,omitempty"`
+	Option3   string    `json:"option3,omitempty"`
+	Price     float64   `json:"price" gorm:"not null"`
+	Stock     int       `json:"stock" gorm:"not null;default:0"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
-type Product struct {
-	ID          int       `json:"id" gorm:"primaryKey"`
-	Name        string    `json:"name" gorm:"not null"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price" gorm:"not null"`
-	Stock       int       `json:"stock" gorm:"default:0"`
-	SellerID    int       `json:"seller_id" gorm:"not null"`
-	Seller      User      `json:"seller,omitempty" gorm:"foreignKey:SellerID"`
-	CreatedAt   time.Time `json:"created_at"`
+// ProductImage is an uploaded product photo. The files live in storage under
+// Key and ThumbnailKeys; URL and Thumbnails are filled in for responses.
+type ProductImage struct {
+	ID            int               `json:"id" gorm:"primaryKey"`
+	ProductID     int               `json:"product_id" gorm:"not null;index"`
+	Key           string            `json:"-" gorm:"not null"`
+	ThumbnailKeys map[string]string `json:"-" gorm:"serializer:json"`
+	ContentType   string            `json:"content_type" gorm:"not null"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Position      int               `json:"position" gorm:"not null;default:0"`
+	IsPrimary     bool              `json:"primary" gorm:"not null;default:false"`
+	URL           string            `json:"url" gorm:"-"`
+	Thumbnails    map[string]string `json:"thumbnails,omitempty" gorm:"-"`
+	CreatedAt     time.Time         `json:"created_at"`
 }
 
-type CartItem struct {
+// Review is a buyer's rating of a product they received. AuthorName is filled
+// in for responses.
+type Review struct {
+	ID               int            `json:"id" gorm:"primaryKey"`
+	ProductID        int            `json:"product_id" gorm:"not null;uniqueIndex:idx_review_product_user"`
+	UserID           int            `json:"user_id" gorm:"not null;uniqueIndex:idx_review_product_user"`
+	User             *User          `json:"-" gorm:"foreignKey:UserID"`
+	AuthorName       string         `json:"author_name" gorm:"-"`
+	Rating           int            `json:"rating" gorm:"not null;index"`
+	Title            string         `json:"title"`
+	Body             string         `json:"body" gorm:"type:text"`
+	VerifiedPurchase bool           `json:"verified_purchase" gorm:"not null;default:false"`
+	Status           string         `json:"status" gorm:"not null;default:'published';index"`
+	SellerReply      string         `json:"seller_reply,omitempty" gorm:"type:text"`
+	SellerRepliedAt  *time.Time     `json:"seller_replied_at,omitempty"`
+	Reports          []ReviewReport `json:"reports,omitempty" gorm:"constraint:OnDelete:CASCADE"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+}
+
+// ReviewReport flags a review as inappropriate; each user may report a review once
+type ReviewReport struct {
 	ID        int       `json:"id" gorm:"primaryKey"`
-	UserID    int       `json:"user_id" gorm:"not null"`
-	ProductID int       `json:"product_id" gorm:"not null"`
-	Quantity  int       `json:"quantity" gorm:"not null"`
-	User      User      `json:"user" gorm:"foreignKey:UserID"`
-	Product   Product   `json:"product" gorm:"foreignKey:ProductID"`
+	ReviewID  int       `json:"review_id" gorm:"not null;uniqueIndex:idx_report_review_user"`
+	UserID    int       `json:"user_id" gorm:"not null;uniqueIndex:idx_report_review_user"`
+	Reason    string    `json:"reason"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Category is a node in the category tree. Siblings are ordered by Position,
+// then name.
+type Category struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	Name      string    `json:"name" gorm:"not null"`
+	Slug      string    `json:"slug" gorm:"uniqueIndex;not null"`
+	ParentID  *int      `json:"parent_id" gorm:"index"`
+	Position  int       `json:"position" gorm:"not null;default:0"`
+	Parent    *Category `json:"-" gorm:"foreignKey:ParentID"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CartItem struct {
+	ID        int             `json:"id" gorm:"primaryKey"`
+	UserID    int             `json:"user_id" gorm:"not null"`
+	ProductID int             `json:"product_id" gorm:"not null"`
+	VariantID *int            `json:"variant_id"`
+	Quantity  int             `json:"quantity" gorm:"not null"`
+	User      User            `json:"user" gorm:"foreignKey:UserID"`
+	Product   Product         `json:"product" gorm:"foreignKey:ProductID"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID;constraint:OnDelete:CASCADE"`
+	CreatedAt time.Time       `json:"created_at"`
+	DeletedAt gorm.DeletedAt  `json:"deleted_at,omitzero" gorm:"index"`
+}
+
 type Order struct {
 	ID         int         `json:"id" gorm:"primaryKey"`
 	UserID     int         `json:"user_id" gorm:"not null"`
@@ -45,12 +176,156 @@ type Order struct {
 }
 
 type OrderItem struct {
-	ID        int     `json:"id" gorm:"primaryKey"`
-	OrderID   int     `json:"order_id" gorm:"not null"`
-	ProductID int     `json:"product_id" gorm:"not null"`
-	Quantity  int     `json:"quantity" gorm:"not null"`
-	Price     float64 `json:"price" gorm:"not null"`
-	Status    string  `json:"status" gorm:"default:'pending'"`
-	Product   Product `json:"product" gorm:"foreignKey:ProductID"`
-	Order     Order   `json:"-" gorm:"foreignKey:OrderID"`
+	ID        int             `json:"id" gorm:"primaryKey"`
+	OrderID   int             `json:"order_id" gorm:"not null"`
+	ProductID int             `json:"product_id" gorm:"not null"`
+	VariantID *int            `json:"variant_id"`
+	Quantity  int             `json:"quantity" gorm:"not null"`
+	Price     float64         `json:"price" gorm:"not null"`
+	Status    string          `json:"status" gorm:"default:'pending'"`
+	Product   Product         `json:"product" gorm:"foreignKey:ProductID"`
+	Variant   *ProductVariant `json:"variant,omitempty" gorm:"foreignKey:VariantID"`
+	Order     Order           `json:"-" gorm:"foreignKey:OrderID"`
+}
+
+type RefreshToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id" gorm:"not null;index"`
+	FamilyID  string     `json:"family_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	MFA       bool       `json:"mfa" gorm:"default:false"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type RevokedToken struct {
+	JTI       string    `json:"jti" gorm:"primaryKey"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserToken is a single-use token sent to a user by email, such as a password reset link
+type UserToken struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RecoveryCode is a hashed single-use code that stands in for a TOTP code
+type RecoveryCode struct {
+	ID        int        `json:"id" gorm:"primaryKey"`
+	UserID    int        `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	User      User       `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// SigningKey is a JWT signing key shared by every API instance. A key signs new
+// tokens until RetiresAt and verifies them until ExpiresAt.
+type SigningKey struct {
+	ID         string    `json:"kid" gorm:"primaryKey"`
+	Algorithm  string    `json:"alg" gorm:"not null"`
+	PrivateKey string    `json:"-" gorm:"not null"`
+	RetiresAt  time.Time `json:"retires_at" gorm:"not null"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// LoginAttempt tracks failed logins for an account or IP address
+type LoginAttempt struct {
+	Key               string     `json:"key" gorm:"primaryKey"`
+	Failures          int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt     time.Time  `json:"last_failure_at" gorm:"index"`
+	PreviousFailureAt *time.Time `json:"previous_failure_at"`
+	LockedUntil       *time.Time `json:"locked_until"`
+}
+
+// APIKey lets a seller's integration call the API without a user session. Only
+// a hash of the key is stored; Prefix identifies it in listings.
+type APIKey struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	UserID     int        `json:"user_id" gorm:"not null;index"`
+	Name       string     `json:"name" gorm:"not null"`
+	Prefix     string     `json:"prefix" gorm:"not null"`
+	KeyHash    string     `json:"-" gorm:"uniqueIndex;not null"`
+	Scopes     []string   `json:"scopes" gorm:"serializer:json"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// UserIdentity links a user to an account at an external OpenID Connect provider
+type UserIdentity struct {
+	ID        int       `json:"id" gorm:"primaryKey"`
+	UserID    int       `json:"user_id" gorm:"not null;index"`
+	Provider  string    `json:"provider" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Subject   string    `json:"subject" gorm:"not null;uniqueIndex:idx_identity_provider_subject"`
+	Email     string    `json:"email"`
+	User      User      `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// OIDCLoginState holds the state, nonce and PKCE verifier of an external login
+// between the redirect to the provider and its callback
+type OIDCLoginState struct {
+	StateHash    string    `gorm:"primaryKey"`
+	Provider     string    `gorm:"not null"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
+
+// Session is a device a user is logged in on. Its ID is the refresh token
+// family ID and is carried in access tokens as the sid claim.
+type Session struct {
+	ID         string     `json:"id" gorm:"primaryKey"`
+	UserID     int        `json:"-" gorm:"not null;index"`
+	Device     string     `json:"device"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at" gorm:"not null;index"`
+	RevokedAt  *time.Time `json:"-"`
+	User       User       `json:"-" gorm:"foreignKey:UserID"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Job is a unit of background work run by the jobs package
+type Job struct {
+	ID         int        `json:"id" gorm:"primaryKey"`
+	Type       string     `json:"type" gorm:"not null;index"`
+	UserID     int        `json:"user_id" gorm:"index"`
+	Payload    string     `json:"-" gorm:"type:text"`
+	Status     string     `json:"status" gorm:"not null;default:'queued';index"`
+	Attempts   int        `json:"attempts" gorm:"not null;default:0"`
+	Progress   int        `json:"progress" gorm:"not null;default:0"`
+	Total      int        `json:"total,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	Result     string     `json:"-"`
+	RunAt      time.Time  `json:"run_at" gorm:"not null;index"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// AuditLog records a sensitive action taken by staff
+type AuditLog struct {
+	ID        int                    `json:"id" gorm:"primaryKey"`
+	ActorID   int                    `json:"actor_id" gorm:"not null;index"`
+	SubjectID int                    `json:"subject_id" gorm:"index"` // the user acted upon, if any
+	Action    string                 `json:"action" gorm:"not null;index"`
+	IPAddress string                 `json:"ip_address"`
+	Details   map[string]interface{} `json:"details,omitempty" gorm:"serializer:json"`
+	CreatedAt time.Time              `json:"created_at" gorm:"index"`
 }
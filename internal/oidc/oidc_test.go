@@ -0,0 +1,126 @@
+package oidc_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/MdHisham-04/E-Commerce/internal/oidc"
+	"github.com/MdHisham-04/E-Commerce/internal/oidc/oidctest"
+)
+
+const verifier = "a-code-verifier-that-is-long-enough-for-pkce-0123456789"
+
+func newProvider(t *testing.T) (*oidctest.Provider, *oidc.Provider) {
+	t.Helper()
+	mock := oidctest.NewProvider("client")
+	t.Cleanup(mock.Close)
+	return mock, oidc.NewProvider(mock.Config("mock", "http://app.test/callback"))
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	mock, provider := newProvider(t)
+
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != mock.URL+"/authorize" {
+		t.Errorf("authorization endpoint = %s, want %s/authorize", got, mock.URL)
+	}
+
+	query := u.Query()
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             "client",
+		"redirect_uri":          "http://app.test/callback",
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        oidc.CodeChallenge(verifier),
+		"code_challenge_method": "S256",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	mock, provider := newProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code, err := mock.Authorize(authURL, oidctest.Identity{
+		Subject:       "subject",
+		Email:         "ada@example.com",
+		EmailVerified: true,
+		Name:          "Ada",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idToken, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Subject != "subject" || idToken.Email != "ada@example.com" || !bool(idToken.EmailVerified) || idToken.Nonce != "nonce" {
+		t.Errorf("unexpected ID token claims %+v", idToken)
+	}
+
+	// Codes are single use
+	if _, err := provider.Exchange(ctx, code, verifier); err == nil {
+		t.Error("code redeemed twice")
+	}
+}
+
+func TestExchangePKCE(t *testing.T) {
+	mock, provider := newProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code, err := mock.Authorize(authURL, oidctest.Identity{Subject: "subject"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An intercepted code is useless without the verifier
+	if _, err := provider.Exchange(ctx, code, "another-verifier"); err == nil {
+		t.Error("code redeemed with the wrong verifier")
+	}
+}
+
+func TestExchangeReturnsProviderNonce(t *testing.T) {
+	mock, provider := newProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, code, err := mock.Authorize(authURL, oidctest.Identity{Subject: "subject", Nonce: "replayed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller compares the nonce, so it must come back as issued
+	idToken, err := provider.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idToken.Nonce != "replayed" {
+		t.Errorf("nonce = %q, want %q", idToken.Nonce, "replayed")
+	}
+}
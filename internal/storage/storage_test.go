@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoragePath(t *testing.T) {
+	s := &LocalStorage{Dir: filepath.FromSlash("/srv/uploads")}
+
+	tests := []struct {
+		key  string
+		want string // empty when the key is refused
+	}{
+		{"products/1/image.png", "/srv/uploads/products/1/image.png"},
+		{"/products/1/image.png", "/srv/uploads/products/1/image.png"},
+		{"../../etc/passwd", "/srv/uploads/etc/passwd"},
+		{"products/../../../etc/passwd", "/srv/uploads/etc/passwd"},
+		{"products/./1//image.png", "/srv/uploads/products/1/image.png"},
+		{"", ""},
+		{"..", ""},
+		{"products/..", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := s.path(tt.key)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("path(%q) = %q, want an error", tt.key, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("path(%q): %v", tt.key, err)
+			}
+			if got != filepath.FromSlash(tt.want) {
+				t.Errorf("path(%q) = %q, want %q", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocalStoragePutStaysInDir(t *testing.T) {
+	root := t.TempDir()
+	s := &LocalStorage{Dir: filepath.Join(root, "uploads")}
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "../escaped.txt", []byte("data"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("file written outside the storage directory")
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, "escaped.txt"))
+	if err != nil || string(data) != "data" {
+		t.Errorf("stored file = %q, %v; want %q", data, err, "data")
+	}
+
+	if err := s.Delete(ctx, "../escaped.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(s.Dir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Error("file not deleted")
+	}
+	if err := s.Delete(ctx, "../escaped.txt"); err != nil {
+		t.Errorf("deleting a missing file: %v", err)
+	}
+
+	entries, _ := os.ReadDir(s.Dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
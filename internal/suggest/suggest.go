@@ -0,0 +1,230 @@
+package suggest
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Entry is a searchable term, such as a product or category name
+type Entry struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+}
+
+// Suggestion is an entry matching what the user has typed
+type Suggestion struct {
+	Entry
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// Loader returns every entry the index should contain
+type Loader func() ([]Entry, error)
+
+type indexed struct {
+	Entry
+	lower string
+	words []string
+}
+
+// Index answers suggestion queries from memory. It is rebuilt from its loader
+// on first use after Invalidate, and every maxAge so changes made by other
+// instances are picked up.
+type Index struct {
+	load   Loader
+	maxAge time.Duration
+
+	mu      sync.RWMutex
+	entries []indexed
+	builtAt time.Time
+	stale   bool
+
+	rebuild sync.Mutex
+}
+
+// New creates an empty index that loads its entries on first use
+func New(load Loader, maxAge time.Duration) *Index {
+	return &Index{load: load, maxAge: maxAge, stale: true}
+}
+
+// Invalidate marks the index for rebuilding before the next query
+func (idx *Index) Invalidate() {
+	idx.mu.Lock()
+	idx.stale = true
+	idx.mu.Unlock()
+}
+
+func (idx *Index) snapshot() []indexed {
+	idx.mu.RLock()
+	fresh := !idx.stale && time.Since(idx.builtAt) < idx.maxAge
+	entries := idx.entries
+	idx.mu.RUnlock()
+	if fresh {
+		return entries
+	}
+
+	// One caller rebuilds while concurrent callers wait for the result
+	idx.rebuild.Lock()
+	defer idx.rebuild.Unlock()
+
+	idx.mu.RLock()
+	fresh = !idx.stale && time.Since(idx.builtAt) < idx.maxAge
+	entries = idx.entries
+	idx.mu.RUnlock()
+	if fresh {
+		return entries
+	}
+
+	loaded, err := idx.load()
+	if err != nil {
+		// Serve the previous entries rather than failing every keystroke
+		log.Println("Failed to rebuild suggestion index:", err)
+		return entries
+	}
+
+	entries = make([]indexed, len(loaded))
+	for i, entry := range loaded {
+		lower := strings.ToLower(entry.Text)
+		entries[i] = indexed{Entry: entry, lower: lower, words: strings.FieldsFunc(lower, isSeparator)}
+	}
+
+	idx.mu.Lock()
+	idx.entries, idx.builtAt, idx.stale = entries, time.Now(), false
+	idx.mu.Unlock()
+	return entries
+}
+
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+}
+
+// Suggest returns up to limit entries whose text starts with q, has a word
+// starting with q, or, when there are too few of those, has a word starting
+// with a slight misspelling of q
+func (idx *Index) Suggest(q string, limit int) []Suggestion {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" || limit < 1 {
+		return []Suggestion{}
+	}
+
+	type match struct {
+		entry *indexed
+		rank  int // lower is better
+	}
+	var matches []match
+	var fuzzy []match
+
+	// Misspellings are only tolerated once enough has been typed to mean something
+	maxDistance := 0
+	switch n := len([]rune(q)); {
+	case n >= 7:
+		maxDistance = 2
+	case n >= 3:
+		maxDistance = 1
+	}
+
+	entries := idx.snapshot()
+	for i := range entries {
+		entry := &entries[i]
+		switch {
+		case strings.HasPrefix(entry.lower, q):
+			matches = append(matches, match{entry, 0})
+		case hasWordPrefix(entry, q):
+			matches = append(matches, match{entry, 1})
+		case maxDistance > 0:
+			if d := closestWordPrefix(entry.words, q, maxDistance); d <= maxDistance {
+				fuzzy = append(fuzzy, match{entry, 1 + d})
+			}
+		}
+	}
+	if len(matches) < limit {
+		matches = append(matches, fuzzy...)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].rank != matches[j].rank {
+			return matches[i].rank < matches[j].rank
+		}
+		if len(matches[i].entry.lower) != len(matches[j].entry.lower) {
+			return len(matches[i].entry.lower) < len(matches[j].entry.lower)
+		}
+		return matches[i].entry.lower < matches[j].entry.lower
+	})
+
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	suggestions := make([]Suggestion, len(matches))
+	for i, m := range matches {
+		suggestions[i] = Suggestion{Entry: m.entry.Entry, Fuzzy: m.rank > 1}
+	}
+	return suggestions
+}
+
+func hasWordPrefix(entry *indexed, q string) bool {
+	for _, word := range entry.words {
+		if strings.HasPrefix(word, q) {
+			return true
+		}
+	}
+	// Queries spanning several words match from any word boundary
+	return strings.Contains(q, " ") && strings.Contains(entry.lower, " "+q)
+}
+
+// closestWordPrefix returns the smallest edit distance between q and the
+// prefix of any word, or max+1 if none is within max
+func closestWordPrefix(words []string, q string, max int) int {
+	best := max + 1
+	qr := []rune(q)
+	for _, word := range words {
+		wr := []rune(word)
+		if len(wr) < len(qr)-max {
+			continue
+		}
+		if len(wr) > len(qr)+max {
+			wr = wr[:len(qr)+max]
+		}
+		if d := prefixDistance(qr, wr, max); d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// prefixDistance is the smallest Levenshtein distance between a and any prefix
+// of b, returning max+1 as soon as it is known to exceed max
+func prefixDistance(a, b []rune, max int) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	best := prev[0]
+	for _, d := range prev[1:] {
+		best = min(best, d)
+	}
+	if best > max {
+		return max + 1
+	}
+	return best
+}